@@ -9,8 +9,15 @@ import (
 
 	"github.com/mhoertnagl/noodles/internal/asm"
 	"github.com/mhoertnagl/noodles/internal/cmp"
-	"github.com/mhoertnagl/noodles/internal/rwr"
+	"github.com/mhoertnagl/noodles/internal/session"
 	"github.com/mhoertnagl/noodles/internal/util"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+var (
+	expand  = flag.Bool("E", false, "print the program with all macros expanded")
+	ast     = flag.Bool("ast", false, "print the program as parsed")
+	listing = flag.Bool("S", false, "write the assembly listing to a .nasm file")
 )
 
 func main() {
@@ -31,16 +38,6 @@ func main() {
 		filepath.Dir(srcPath),
 	}
 
-	rdr := cmp.NewReader()
-	prs := cmp.NewParser()
-	urw := rwr.NewUseRewriter(dirs)
-	qrw := rwr.NewQuoteRewriter()
-	mrw := rwr.NewMacroRewriter()
-	cmp := cmp.NewCompiler()
-	asm := asm.NewAssembler()
-
-	cmp.AddDefaultGlobals()
-
 	srcBytes, err := ioutil.ReadFile(srcPath)
 	if err != nil {
 		fmt.Println(err)
@@ -52,53 +49,90 @@ func main() {
 		os.Exit(-1)
 	}
 
-	rdr.Load(string(srcBytes))
-	n := prs.Parse(rdr)
-
-	if len(prs.Errors()) > 0 {
-		for _, err := range prs.Errors() {
-			fmt.Println(err.Msg)
-		}
-		os.Exit(-1)
+	if *ast || *expand {
+		printAst(dirs, srcPath, string(srcBytes))
+		return
 	}
 
-	n = urw.Rewrite(n)
+	outPath := util.FilePathWithoutExt(srcPath)
+
+	// Assembly listings are assembled directly.
+	if filepath.Ext(srcPath) == ".nasm" {
+		writeObject(outPath, assemble(string(srcBytes)))
+		return
+	}
 
-	if len(urw.Errors()) > 0 {
-		for _, err := range urw.Errors() {
-			fmt.Println(err)
-		}
+	prg, err := session.Compile(dirs, srcPath, string(srcBytes))
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(-1)
 	}
 
-	n = qrw.Rewrite(n)
+	if *listing {
+		writeListing(outPath, prg)
+		return
+	}
 
-	n = mrw.Rewrite(n)
+	writeObject(outPath, &vm.Object{
+		Code:    prg.Code,
+		Globals: prg.Globals,
+		Debug:   prg.Debug,
+	})
+}
 
-	if len(mrw.Errors()) > 0 {
-		for _, err := range mrw.Errors() {
-			fmt.Println(err)
-		}
+// assemble assembles the assembly listing src.
+func assemble(src string) *vm.Object {
+	code, err := asm.NewAsmParser().Parse(src)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(-1)
 	}
+	a := asm.NewAssembler()
+	return &vm.Object{
+		Code:    a.Assemble(code),
+		Globals: code.Globals(),
+		Debug:   a.DebugInfo(),
+	}
+}
 
-	a := cmp.Compile(n)
-
-	if len(cmp.Errors()) > 0 {
-		for _, err := range cmp.Errors() {
-			fmt.Println(err)
-		}
+func writeObject(outPath string, obj *vm.Object) {
+	outFile, err := os.Create(outPath + ".nob")
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(-1)
 	}
+	util.WriteStatic(obj.Encode(), outFile)
+}
 
-	c := asm.Assemble(a)
+// writeListing writes the assembly of the program preceded by the names of
+// its globals.
+func writeListing(outPath string, prg *session.Program) {
+	code := asm.AsmCode{}
+	for id, name := range prg.Globals {
+		code = append(code, asm.Global(uint64(id), name))
+	}
+	code = append(code, prg.Asm...)
+	src := asm.NewAsmPrinter().PrintToStr(code)
+	if err := ioutil.WriteFile(outPath+".nasm", []byte(src), 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
 
-	outPath := util.FilePathWithoutExt(srcPath)
-	outFile, err := os.Create(outPath + ".nob")
+// printAst prints the parsed or expanded program instead of compiling it.
+func printAst(dirs []string, srcPath string, src string) {
+	var n cmp.Node
+	var err error
+	if *expand {
+		n, err = session.Expand(dirs, srcPath, src)
+	} else {
+		n, err = session.Parse(srcPath, src)
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
-
-	util.WriteStatic(c, outFile)
+	if n != nil {
+		fmt.Println(cmp.PrettyPrintAst(n))
+	}
 }
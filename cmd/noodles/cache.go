@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mhoertnagl/noodles/internal/session"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// cacheEntry is a compiled program along with the hashes of all the modules
+// it uses. The entry is stale as soon as one of the modules changes or a
+// module of the same name appears in a directory that is searched first.
+type cacheEntry struct {
+	Modules []cachedModule
+	Object  []byte
+}
+
+// cachedModule is a used module file. Shadows are the paths of the module in
+// the directories that are searched before the directory of the file. None of
+// them existed when the entry was written.
+type cachedModule struct {
+	Path    string
+	Hash    string
+	Shadows []string
+}
+
+// cachePath returns the path of the cache entry for a source file. The entry
+// is named after the hash of the path and the contents of the source file and
+// the directories that are searched for used modules. It returns the empty
+// string if there is no cache directory.
+func cachePath(srcPath string, src []byte, dirs []string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(srcPath)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(abs))
+	h.Write([]byte{0})
+	for _, d := range dirs {
+		if a, err := filepath.Abs(d); err == nil {
+			d = a
+		}
+		h.Write([]byte(d))
+		h.Write([]byte{0})
+	}
+	h.Write(src)
+	return filepath.Join(dir, "noodles", hex.EncodeToString(h.Sum(nil))+".cache")
+}
+
+// loadCached reads a cache entry. It fails if the entry does not exist or if
+// any of the used modules has changed.
+func loadCached(path string) (*session.Program, bool) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&e); err != nil {
+		return nil, false
+	}
+	mods := []string{}
+	for _, mod := range e.Modules {
+		if hashFile(mod.Path) != mod.Hash {
+			return nil, false
+		}
+		for _, p := range mod.Shadows {
+			if _, err := os.Stat(p); err == nil {
+				return nil, false
+			}
+		}
+		mods = append(mods, mod.Path)
+	}
+	// Entries written for a different instruction set are rejected.
+	obj, err := vm.DecodeObject(e.Object)
+	if err != nil {
+		return nil, false
+	}
+	return &session.Program{Code: obj.Code, Debug: obj.Debug, Globals: obj.Globals, Modules: mods}, true
+}
+
+// storeCached writes a cache entry for the program whose modules have been
+// searched for in dirs. Failures are ignored since the program can always be
+// compiled again.
+func storeCached(path string, prg *session.Program, dirs []string) {
+	obj := &vm.Object{
+		Code:    prg.Code,
+		Globals: prg.Globals,
+		Debug:   prg.Debug,
+	}
+	e := cacheEntry{
+		Modules: []cachedModule{},
+		Object:  obj.Encode(),
+	}
+	for _, mod := range prg.Modules {
+		e.Modules = append(e.Modules, cachedModule{
+			Path:    mod,
+			Hash:    hashFile(mod),
+			Shadows: shadows(mod, dirs),
+		})
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// shadows returns the paths in the directories that precede the directory of
+// the module file mod in dirs. A module file at one of these paths would be
+// used instead of mod.
+func shadows(mod string, dirs []string) []string {
+	ps := []string{}
+	for i, d := range dirs {
+		rel, err := filepath.Rel(d, mod)
+		if err != nil || strings.HasPrefix(rel, "..") || filepath.Join(d, rel) != mod {
+			continue
+		}
+		for _, s := range dirs[:i] {
+			ps = append(ps, filepath.Join(s, rel))
+		}
+		return ps
+	}
+	return ps
+}
+
+// hashFile returns the hex encoded SHA-256 hash of the file contents or the
+// empty string if the file cannot be read.
+func hashFile(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
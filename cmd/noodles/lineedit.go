@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Control characters understood by the line editor.
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyBackspace = 8
+	keyEnter     = 13
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// lineReader reads lines from the standard input. On a terminal the line can
+// be edited and previous entries are recalled with the up and down keys.
+// Otherwise lines are read as they are.
+type lineReader struct {
+	in    *bufio.Reader
+	tty   bool
+	state string
+}
+
+// newLineReader puts the terminal into raw mode if the standard input is a
+// terminal. Close restores the previous mode.
+func newLineReader() *lineReader {
+	r := &lineReader{in: bufio.NewReader(os.Stdin)}
+	state, err := stty("-g")
+	if err != nil {
+		return r
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return r
+	}
+	r.tty = true
+	r.state = strings.TrimSpace(state)
+	return r
+}
+
+// Close restores the mode of the terminal.
+func (r *lineReader) Close() {
+	if r.tty {
+		stty(r.state)
+		r.tty = false
+	}
+}
+
+// stty runs the stty command on the standard input.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// ReadLine prints the prompt and reads a line. The up and down keys select
+// an entry of hist. It returns false at the end of the input.
+func (r *lineReader) ReadLine(prompt string, hist []string) (string, bool) {
+	fmt.Print(prompt)
+	if !r.tty {
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+
+	e := &lineEditor{prompt: prompt, hist: hist, idx: len(hist)}
+	for {
+		c, _, err := r.in.ReadRune()
+		if err != nil {
+			return "", false
+		}
+		switch c {
+		case keyEnter, '\n':
+			fmt.Print("\n")
+			return string(e.line), true
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				fmt.Print("\n")
+				return "", false
+			}
+		case keyCtrlC:
+			// Discards the line.
+			fmt.Print("^C\n")
+			return "", true
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyBackspace, keyDelete:
+			e.backspace()
+		case keyEscape:
+			r.escape(e)
+		default:
+			if c >= ' ' {
+				e.insert(c)
+			}
+		}
+		e.redraw()
+	}
+}
+
+// escape handles the escape sequences of the arrow, home and end keys.
+func (r *lineReader) escape(e *lineEditor) {
+	if c, _, _ := r.in.ReadRune(); c != '[' && c != 'O' {
+		return
+	}
+	c, _, _ := r.in.ReadRune()
+	switch c {
+	case 'A':
+		e.recall(-1)
+	case 'B':
+		e.recall(1)
+	case 'C':
+		if e.pos < len(e.line) {
+			e.pos++
+		}
+	case 'D':
+		if e.pos > 0 {
+			e.pos--
+		}
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.line)
+	}
+}
+
+// lineEditor is the state of the line that is being edited.
+type lineEditor struct {
+	prompt string
+	line   []rune
+	pos    int
+	hist   []string
+	// idx is the selected history entry. It is len(hist) for the new line.
+	idx int
+	// saved keeps the new line while browsing the history.
+	saved []rune
+}
+
+func (e *lineEditor) insert(c rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = c
+	e.pos++
+}
+
+func (e *lineEditor) backspace() {
+	if e.pos == 0 {
+		return
+	}
+	e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+	e.pos--
+}
+
+// recall replaces the line with the previous (d = -1) or the next (d = 1)
+// history entry.
+func (e *lineEditor) recall(d int) {
+	idx := e.idx + d
+	if idx < 0 || idx > len(e.hist) {
+		return
+	}
+	if e.idx == len(e.hist) {
+		e.saved = e.line
+	}
+	e.idx = idx
+	if idx == len(e.hist) {
+		e.line = e.saved
+	} else {
+		e.line = []rune(e.hist[idx])
+	}
+	e.pos = len(e.line)
+}
+
+// redraw prints the line and moves the cursor to its position.
+func (e *lineEditor) redraw() {
+	fmt.Printf("\r%s%s\x1b[K", e.prompt, string(e.line))
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Printf("\x1b[%dD", n)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
 	"github.com/mhoertnagl/noodles/internal/util"
@@ -9,16 +10,58 @@ import (
 )
 
 func main() {
+	lim := addLimitFlags(flag.CommandLine)
 	flag.Parse()
 
-	vm := vm.NewVM(1024, 512, 512)
-	vm.AddDefaultGlobals()
+	switch flag.Arg(0) {
+	case "repl":
+		repl(lim)
+		return
+	case "run":
+		run(flag.Args()[1:])
+		return
+	}
+
+	m := vm.NewVM(1024, 0, 512)
+	m.SetLimits(lim.stack, lim.frame)
+	m.AddDefaultGlobals()
 
 	for _, inFileName := range flag.Args() {
 		inFile, err := os.Open(inFileName)
 		if err != nil {
 			panic(err)
 		}
-		vm.Run(util.ReadStatic(inFile))
+		obj, err := vm.DecodeObject(util.ReadStatic(inFile))
+		inFile.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", inFileName, err)
+			os.Exit(1)
+		}
+		m.ReserveGlobals(len(obj.Globals))
+		m.SetDebugInfo(obj.Debug)
+		if err := m.Run(obj.Code); err != nil {
+			reportError(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// limits are the maximum sizes of the stacks given on the command line.
+type limits struct {
+	stack int64
+	frame int64
+}
+
+func addLimitFlags(fs *flag.FlagSet) *limits {
+	lim := &limits{}
+	fs.Int64Var(&lim.stack, "stack-limit", vm.DefaultStackLimit, "maximum number of values on the stack")
+	fs.Int64Var(&lim.frame, "frame-limit", vm.DefaultFrameLimit, "maximum number of values on the frames stack")
+	return lim
+}
+
+func reportError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	if rerr, ok := err.(*vm.RuntimeError); ok {
+		fmt.Fprint(os.Stderr, rerr.Backtrace())
 	}
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mhoertnagl/noodles/internal/session"
+	"github.com/mhoertnagl/noodles/internal/util"
+)
+
+const (
+	prompt     = "noodles> "
+	contPrompt = "     ... "
+)
+
+// repl reads forms from the standard input, evaluates them and prints their
+// results. The core prelude is loaded beforehand.
+func repl(lim *limits) {
+	s := session.New([]string{util.SplisLibPath(), "."})
+	s.SetLimits(lim.stack, lim.frame)
+	if _, _, err := s.Eval("", `(use "core/prelude")`); err != nil {
+		reportError(err)
+	}
+
+	hist := loadHistory()
+	in := newLineReader()
+	defer in.Close()
+	var src strings.Builder
+
+	p := prompt
+	for {
+		line, ok := in.ReadLine(p, hist)
+		if !ok {
+			break
+		}
+		if src.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if !command(strings.TrimSpace(line), hist) {
+				return
+			}
+			continue
+		}
+		src.WriteString(line)
+		src.WriteString("\n")
+		// Continue reading lines until all parentheses are balanced.
+		if !session.Complete(src.String()) {
+			p = contPrompt
+			continue
+		}
+		p = prompt
+		entry := strings.TrimSpace(src.String())
+		src.Reset()
+		if entry != "" {
+			hist = appendHistory(hist, entry)
+			eval(s, entry)
+		}
+	}
+	fmt.Println()
+}
+
+func eval(s *session.Session, entry string) {
+	v, ok, err := s.Eval("<repl>", entry)
+	if err != nil {
+		reportError(err)
+		return
+	}
+	if ok && v != nil {
+		fmt.Println(v)
+	}
+}
+
+// command executes a REPL command. It returns false if the REPL should quit.
+func command(cmd string, hist []string) bool {
+	switch cmd {
+	case ":quit", ":q":
+		return false
+	case ":history":
+		for i, entry := range hist {
+			fmt.Printf("%4d  %s\n", i+1, entry)
+		}
+	case ":help":
+		fmt.Println("up/down   recall previous entries")
+		fmt.Println(":history  lists previous entries")
+		fmt.Println(":quit     leaves the REPL")
+	default:
+		fmt.Printf("unknown command [%s]\n", cmd)
+	}
+	return true
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".noodles_history")
+}
+
+// loadHistory reads the entries of previous REPL sessions. Every line of the
+// history file holds a single entry.
+func loadHistory() []string {
+	b, err := ioutil.ReadFile(historyPath())
+	if err != nil {
+		return []string{}
+	}
+	hist := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			hist = append(hist, line)
+		}
+	}
+	return hist
+}
+
+// appendHistory adds the entry to the history and the history file. Entries
+// that span multiple lines are stored on a single line.
+func appendHistory(hist []string, entry string) []string {
+	entry = strings.Replace(entry, "\n", " ", -1)
+	hist = append(hist, entry)
+	path := historyPath()
+	if path == "" {
+		return hist
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return hist
+	}
+	defer f.Close()
+	fmt.Fprintln(f, entry)
+	return hist
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mhoertnagl/noodles/internal/session"
+	"github.com/mhoertnagl/noodles/internal/util"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// run compiles a source file in memory and executes it. The remaining command
+// line arguments are passed to the program in *ARGS*.
+func run(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	noCache := fs.Bool("no-cache", false, "always compile the source file")
+	lim := addLimitFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: noodles run [-no-cache] [-stack-limit n] [-frame-limit n] file.splis [args...]")
+		os.Exit(2)
+	}
+
+	srcPath := fs.Arg(0)
+	src, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	prg, err := compile(srcPath, src, !*noCache)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	m := vm.NewVM(1024, 0, 512)
+	m.ReserveGlobals(len(prg.Globals))
+	m.AddDefaultGlobals()
+	m.SetArgs(fs.Args()[1:])
+	m.SetLimits(lim.stack, lim.frame)
+	m.SetDebugInfo(prg.Debug)
+	if err := m.Run(prg.Code); err != nil {
+		reportError(err)
+		os.Exit(1)
+	}
+}
+
+// compile compiles the source file. If useCache is set, the program will be
+// taken from the cache if neither the source nor any of the used modules have
+// changed since it was cached.
+func compile(srcPath string, src []byte, useCache bool) (*session.Program, error) {
+	// The compiler will search for used modules in '$(SPLIS_HOME)/lib' and the
+	// directory that contians the input source file.
+	dirs := []string{
+		util.SplisLibPath(),
+		filepath.Dir(srcPath),
+	}
+
+	entry := cachePath(srcPath, src, dirs)
+	if useCache && entry != "" {
+		if prg, ok := loadCached(entry); ok {
+			return prg, nil
+		}
+	}
+
+	prg, err := session.Compile(dirs, srcPath, string(src))
+	if err != nil {
+		return nil, err
+	}
+	if useCache && entry != "" {
+		storeCached(entry, prg, dirs)
+	}
+	return prg, nil
+}
@@ -6,21 +6,36 @@ import (
 
 type Assembler struct {
 	lbls map[string]uint64
+	dbg  *vm.DebugInfo
 }
 
 func NewAssembler() *Assembler {
 	return &Assembler{
 		lbls: make(map[string]uint64),
+		dbg:  vm.NewDebugInfo(),
 	}
 }
 
 func (a *Assembler) Assemble(code AsmCode) []byte {
-	a.locateLabelPositions(code)
-	return a.assemble(code)
+	return a.AssembleAt(code, 0)
 }
 
-func (a *Assembler) locateLabelPositions(code AsmCode) {
-	ip := uint64(0)
+// AssembleAt assembles code that will be located at address base. Labels of
+// previously assembled code remain valid.
+func (a *Assembler) AssembleAt(code AsmCode, base uint64) []byte {
+	a.dbg = vm.NewDebugInfo()
+	a.locateLabelPositions(code, base)
+	return a.assemble(code, base)
+}
+
+// DebugInfo returns the function ranges and source positions collected from
+// the debug markers of the last assembled code.
+func (a *Assembler) DebugInfo() *vm.DebugInfo {
+	return a.dbg
+}
+
+func (a *Assembler) locateLabelPositions(code AsmCode, base uint64) {
+	ip := base
 	for _, line := range code {
 		switch x := line.(type) {
 		case *AsmLabel:
@@ -32,7 +47,7 @@ func (a *Assembler) locateLabelPositions(code AsmCode) {
 		case *AsmIns:
 			ip += a.insInc(x.Op)
 		case *AsmStr:
-			ip += a.insInc(vm.OpStr) + uint64(len(x.Str))
+			ip += a.insInc(x.Op) + uint64(len(x.Str))
 		}
 	}
 }
@@ -45,7 +60,7 @@ func (a *Assembler) insInc(op vm.Op) uint64 {
 	return 1 + uint64(mt.Size())
 }
 
-func (a *Assembler) assemble(code AsmCode) []byte {
+func (a *Assembler) assemble(code AsmCode, base uint64) []byte {
 	bin := make([]byte, 0)
 	for _, line := range code {
 		switch x := line.(type) {
@@ -56,8 +71,28 @@ func (a *Assembler) assemble(code AsmCode) []byte {
 		case *AsmIns:
 			bin = append(bin, vm.Instr(x.Op, x.Args...)...)
 		case *AsmStr:
-			bin = append(bin, vm.Str(x.Str)...)
+			bin = append(bin, vm.StrInstr(x.Op, x.Str)...)
+		case *AsmSrcPos:
+			a.addLine(int64(base)+int64(len(bin)), x.Pos)
+		case *AsmFn:
+			a.dbg.Fns = append(a.dbg.Fns, vm.FnInfo{
+				Name:  x.Name,
+				Start: int64(a.lbls[x.Start]),
+				End:   int64(a.lbls[x.End]),
+				Pos:   x.Pos,
+			})
 		}
 	}
 	return bin
 }
+
+// addLine adds a line entry for the address addr. A previous entry for the
+// same address will be replaced.
+func (a *Assembler) addLine(addr int64, pos vm.SrcPos) {
+	ln := len(a.dbg.Lines)
+	if ln > 0 && a.dbg.Lines[ln-1].Addr == addr {
+		a.dbg.Lines[ln-1].Pos = pos
+		return
+	}
+	a.dbg.Lines = append(a.dbg.Lines, vm.LineInfo{Addr: addr, Pos: pos})
+}
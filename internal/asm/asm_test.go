@@ -896,7 +896,7 @@ func TestAssembleTailFac(t *testing.T) {
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpSub),
 		asm.Instr(vm.OpGetGlobal, 0),
-		asm.Instr(vm.OpRecCall),
+		asm.Instr(vm.OpTailCall),
 		asm.Label("L3"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
@@ -939,7 +939,7 @@ func TestAssembleTailFac(t *testing.T) {
 		vm.Instr(vm.OpConst, 1),
 		vm.Instr(vm.OpSub),
 		vm.Instr(vm.OpGetGlobal, 0),
-		vm.Instr(vm.OpRecCall),
+		vm.Instr(vm.OpTailCall),
 		vm.Instr(vm.OpReturn),
 		vm.Instr(vm.OpRef, 0, 9),
 		vm.Instr(vm.OpSetGlobal, 0),
@@ -1177,6 +1177,41 @@ func TestAssembleHalt(t *testing.T) {
 	testa(t, i, e)
 }
 
+func TestAssembleDebugInfo(t *testing.T) {
+	p1 := vm.SrcPos{File: "t", Line: 1, Col: 1}
+	p2 := vm.SrcPos{File: "t", Line: 2, Col: 3}
+	i := []asm.AsmCmd{
+		asm.SrcPos(p1),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Fn("f", "L1", "L0", p2),
+		asm.Label("L1"),
+		asm.SrcPos(p2),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpTrue),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.SrcPos(p1),
+		asm.Ref(0, "L1"),
+	}
+	e := vm.ConcatVar(
+		vm.Instr(vm.OpJump, 12),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpTrue),
+		vm.Instr(vm.OpReturn),
+		vm.Instr(vm.OpRef, 0, 9),
+	)
+	a := asm.NewAssembler()
+	compareAssembly(t, a.Assemble(i), e)
+	dbg := a.DebugInfo()
+	if len(dbg.Fns) != 1 || dbg.Fns[0] != (vm.FnInfo{Name: "f", Start: 9, End: 12, Pos: p2}) {
+		t.Errorf("Unexpected function info %v", dbg.Fns)
+	}
+	el := []vm.LineInfo{{Addr: 0, Pos: p1}, {Addr: 9, Pos: p2}, {Addr: 12, Pos: p1}}
+	if fmt.Sprint(dbg.Lines) != fmt.Sprint(el) {
+		t.Errorf("Expecting line info %v but got %v", el, dbg.Lines)
+	}
+}
+
 func testa(t *testing.T, i asm.AsmCode, e []byte) {
 	t.Helper()
 	a := asm.NewAssembler()
@@ -27,12 +27,52 @@ type AsmIns struct {
 	Args []uint64
 }
 
+// AsmStr is an instruction with a string operand: either a string or a
+// keyword.
 type AsmStr struct {
+	Op  vm.Op
 	Str string
 }
 
+// AsmSrcPos marks the source position of the subsequent instructions. It
+// does not emit any code.
+type AsmSrcPos struct {
+	Pos vm.SrcPos
+}
+
+// AsmFn marks the code between the labels Start and End as the function Name.
+// It does not emit any code.
+type AsmFn struct {
+	Name  string
+	Start string
+	End   string
+	Pos   vm.SrcPos
+}
+
+// AsmGlobal names the global definition with the ID. It does not emit any
+// code.
+type AsmGlobal struct {
+	ID   uint64
+	Name string
+}
+
 type AsmCode []AsmCmd
 
+// Globals returns the names of the global definitions declared in code
+// indexed by their IDs.
+func (code AsmCode) Globals() []string {
+	names := []string{}
+	for _, line := range code {
+		if x, ok := line.(*AsmGlobal); ok {
+			for uint64(len(names)) <= x.ID {
+				names = append(names, "")
+			}
+			names[x.ID] = x.Name
+		}
+	}
+	return names
+}
+
 func Label(name string) *AsmLabel {
 	return &AsmLabel{Name: name}
 }
@@ -50,7 +90,27 @@ func Instr(op vm.Op, args ...uint64) *AsmIns {
 }
 
 func Str(str string) *AsmStr {
-	return &AsmStr{Str: str}
+	return &AsmStr{Op: vm.OpStr, Str: str}
+}
+
+func Keyword(name string) *AsmStr {
+	return &AsmStr{Op: vm.OpKeyword, Str: name}
+}
+
+func Symbol(name string) *AsmStr {
+	return &AsmStr{Op: vm.OpSymbol, Str: name}
+}
+
+func SrcPos(pos vm.SrcPos) *AsmSrcPos {
+	return &AsmSrcPos{Pos: pos}
+}
+
+func Fn(name string, start string, end string, pos vm.SrcPos) *AsmFn {
+	return &AsmFn{Name: name, Start: start, End: end, Pos: pos}
+}
+
+func Global(id uint64, name string) *AsmGlobal {
+	return &AsmGlobal{ID: id, Name: name}
 }
 
 // func AsmBool(n bool) *AsmIns {
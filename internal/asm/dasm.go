@@ -65,7 +65,7 @@ func (m *Disassembler) write(format string, a ...interface{}) {
 func (m *Disassembler) writeInstr(op vm.Op) {
 	if meta, ok := vm.LookupMeta(op); ok == nil {
 		switch op {
-		case vm.OpStr:
+		case vm.OpStr, vm.OpKeyword, vm.OpSymbol:
 			slen := int64(m.readArg(meta.Args[0]))
 			str := m.readString(slen)
 			m.write("%s '%s'", meta.Name, str)
@@ -0,0 +1,287 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// AsmParser reads assembly in the textual format of AsmPrinter. Every line
+// holds a label, an instruction or a directive. Comments start with a
+// semicolon and extend to the end of the line.
+//
+//   .global 4 inc
+//   L0:
+//     .pos main.splis:1:1
+//     Ref 1 L1 ; a comment
+//     String 'Hello\n'
+type AsmParser struct {
+	line   int
+	code   AsmCode
+	lbls   map[string]bool
+	usages map[string]int
+}
+
+func NewAsmParser() *AsmParser {
+	return &AsmParser{}
+}
+
+// Parse parses the assembly src. It fails on the first malformed line or if
+// a label is used but never defined.
+func (p *AsmParser) Parse(src string) (AsmCode, error) {
+	p.code = make(AsmCode, 0)
+	p.lbls = make(map[string]bool)
+	p.usages = make(map[string]int)
+	for i, line := range strings.Split(src, "\n") {
+		p.line = i + 1
+		if err := p.parseLine(line); err != nil {
+			return nil, err
+		}
+	}
+	for name, line := range p.usages {
+		if !p.lbls[name] {
+			p.line = line
+			return nil, p.error("label [%s] undefined", name)
+		}
+	}
+	return p.code, nil
+}
+
+func (p *AsmParser) error(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *AsmParser) emit(cmd AsmCmd) {
+	p.code = append(p.code, cmd)
+}
+
+// use records the usage of a label. Labels may be used before they are
+// defined.
+func (p *AsmParser) use(name string) {
+	if _, ok := p.usages[name]; !ok {
+		p.usages[name] = p.line
+	}
+}
+
+func (p *AsmParser) parseLine(line string) error {
+	line, err := stripComment(line)
+	if err != nil {
+		return p.error("%v", err)
+	}
+	if line == "" {
+		return nil
+	}
+	if strings.HasSuffix(line, ":") && !strings.ContainsAny(line, " \t'") {
+		name := strings.TrimSuffix(line, ":")
+		if p.lbls[name] {
+			return p.error("label [%s] already defined", name)
+		}
+		p.lbls[name] = true
+		p.emit(Label(name))
+		return nil
+	}
+	if strings.HasPrefix(line, ".") {
+		return p.parseDirective(line)
+	}
+	return p.parseInstr(line)
+}
+
+func (p *AsmParser) parseDirective(line string) error {
+	fs := fieldsN(line, 2)
+	switch fs[0] {
+	case ".global":
+		fs = fieldsN(line, 3)
+		if len(fs) != 3 {
+			return p.error("[.global] requires an ID and a name")
+		}
+		id, err := strconv.ParseUint(fs[1], 10, 64)
+		if err != nil {
+			return p.error("invalid global ID [%s]", fs[1])
+		}
+		p.emit(Global(id, fs[2]))
+	case ".pos":
+		if len(fs) != 2 {
+			return p.error("[.pos] requires a source position")
+		}
+		pos, err := parseSrcPos(fs[1])
+		if err != nil {
+			return p.error("%v", err)
+		}
+		p.emit(SrcPos(pos))
+	case ".fn":
+		fs = fieldsN(line, 5)
+		if len(fs) != 5 {
+			return p.error("[.fn] requires a name, two labels and a source position")
+		}
+		pos, err := parseSrcPos(fs[4])
+		if err != nil {
+			return p.error("%v", err)
+		}
+		p.use(fs[2])
+		p.use(fs[3])
+		p.emit(Fn(fs[1], fs[2], fs[3], pos))
+	default:
+		return p.error("directive [%s] undefined", fs[0])
+	}
+	return nil
+}
+
+func (p *AsmParser) parseInstr(line string) error {
+	fs := fieldsN(line, 2)
+	op, err := vm.LookupOp(fs[0])
+	if err != nil {
+		return p.error("%v", err)
+	}
+	meta, _ := vm.LookupMeta(op)
+
+	switch op {
+	case vm.OpStr, vm.OpKeyword, vm.OpSymbol:
+		if len(fs) != 2 {
+			return p.error("[%s] requires a quoted string", fs[0])
+		}
+		str, err := unquote(fs[1])
+		if err != nil {
+			return p.error("%v", err)
+		}
+		p.emit(&AsmStr{Op: op, Str: str})
+		return nil
+	}
+
+	args := strings.Fields(line)[1:]
+	if len(args) != len(meta.Args) {
+		return p.error("[%s] requires [%d] arguments but got [%d]", fs[0], len(meta.Args), len(args))
+	}
+	vals := make([]uint64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseUint(arg, 10, 64)
+		if err == nil {
+			vals[i] = v
+			continue
+		}
+		// The last argument of jumps and references may be a label.
+		if i == len(args)-1 && isLabeled(op) {
+			p.use(arg)
+			if op == vm.OpRef {
+				p.emit(Ref(int(vals[0]), arg))
+			} else {
+				p.emit(Labeled(op, arg))
+			}
+			return nil
+		}
+		return p.error("invalid argument [%s]", arg)
+	}
+	p.emit(Instr(op, vals...))
+	return nil
+}
+
+// isLabeled returns true if the address operand of the operation may be
+// given as a label.
+func isLabeled(op vm.Op) bool {
+	switch op {
+	case vm.OpJump, vm.OpJumpIf, vm.OpJumpIfNot, vm.OpTry, vm.OpRef:
+		return true
+	}
+	return false
+}
+
+// fieldsN splits s into at most n fields separated by white space. The last
+// field holds the remainder of s.
+func fieldsN(s string, n int) []string {
+	fs := []string{}
+	s = strings.TrimSpace(s)
+	for len(fs) < n-1 && s != "" {
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i < 0 {
+			break
+		}
+		fs = append(fs, s[:i])
+		s = strings.TrimSpace(s[i:])
+	}
+	if s != "" {
+		fs = append(fs, s)
+	}
+	return fs
+}
+
+// stripComment removes the comment and surrounding white space from line.
+// Semicolons in quoted strings do not start a comment.
+func stripComment(line string) (string, error) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case !quoted && c == ';':
+			return strings.TrimSpace(line[:i]), nil
+		}
+	}
+	if quoted {
+		return "", fmt.Errorf("unterminated string")
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// unquote is the inverse of quote.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return "", fmt.Errorf("invalid string [%s]", s)
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 1; i < len(s)-1; i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 < len(s)-1 && s[i+1] == '\'' {
+				b.WriteByte('\'')
+			} else if i+1 < len(s)-1 {
+				b.WriteByte('\\')
+				b.WriteByte(s[i+1])
+			} else {
+				return "", fmt.Errorf("invalid string [%s]", s)
+			}
+			i++
+		case '"':
+			b.WriteString(`\"`)
+		case '\'':
+			return "", fmt.Errorf("invalid string [%s]", s)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	str, err := strconv.Unquote(b.String())
+	if err != nil {
+		return "", fmt.Errorf("invalid string [%s]", s)
+	}
+	return str, nil
+}
+
+// parseSrcPos parses a source position in the format of vm.SrcPos.String.
+// The file name may contain colons.
+func parseSrcPos(s string) (vm.SrcPos, error) {
+	if s == "-" {
+		return vm.SrcPos{}, nil
+	}
+	err := fmt.Errorf("invalid source position [%s]", s)
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return vm.SrcPos{}, err
+	}
+	col, cerr := strconv.Atoi(s[i+1:])
+	file := ""
+	ln := s[:i]
+	if j := strings.LastIndex(ln, ":"); j >= 0 {
+		file = ln[:j]
+		ln = ln[j+1:]
+	}
+	line, lerr := strconv.Atoi(ln)
+	if cerr != nil || lerr != nil || line == 0 {
+		return vm.SrcPos{}, err
+	}
+	return vm.SrcPos{File: file, Line: line, Col: col}, nil
+}
@@ -0,0 +1,136 @@
+package asm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mhoertnagl/noodles/internal/asm"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	testRoundTrip(t, `.global 0 *STD-OUT*
+.global 1 inc
+  Ref 1 L1
+  Jump L0
+L1:
+  .pos inc.splis:1:12
+  PushArgs 1
+  GetArg 0
+  Const 1
+  Add
+  DropArgs 1
+  Return
+L0:
+  .fn inc L1 L0 inc.splis:1:1
+  SetGlobal 1 ; inc
+  .pos -
+  GetGlobal 0 ; *STD-OUT*
+  String 'it\'s a "string"; \\ \n\t\x00'
+  Keyword 'foo'
+  Symbol 'a:b'
+  ConstF 4614253070214989087
+  Is 2
+  Ref 0 26
+  Try L2
+  Throw
+L2:
+  .pos C:\file.splis:3:4
+  Halt
+`)
+}
+
+func TestParseRoundTripStrings(t *testing.T) {
+	for _, s := range []string{"", "'", `\`, `\'`, `"`, "\n;\r", "日本", "\xff"} {
+		code := asm.AsmCode{asm.Str(s)}
+		p := asm.NewAsmPrinter()
+		txt := p.PrintToStr(code)
+		parsed, err := asm.NewAsmParser().Parse(txt)
+		if err != nil {
+			t.Fatalf("Unexpected error [%v] for [%s]", err, txt)
+		}
+		if x, ok := parsed[0].(*asm.AsmStr); !ok || x.Str != s {
+			t.Errorf("Expecting [%q] but got [%v]", s, parsed[0])
+		}
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	code := testParse(t, `
+; leading comment
+L0: ; label
+  True   ; push true
+  JumpIfNot  L0
+`)
+	e := "L0:\n  True\n  JumpIfNot L0\n"
+	if a := asm.NewAsmPrinter().PrintToStr(code); a != e {
+		t.Errorf("Expecting [%s] but got [%s]", e, a)
+	}
+}
+
+func TestParseAssemble(t *testing.T) {
+	code := testParse(t, `
+  True
+  JumpIfNot L0
+  Const 1
+  Jump L1
+L0:
+  Const 2
+L1:
+`)
+	e := vm.ConcatVar(
+		vm.Instr(vm.OpTrue),
+		vm.Instr(vm.OpJumpIfNot, 28),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpJump, 37),
+		vm.Instr(vm.OpConst, 2),
+	)
+	if a := asm.NewAssembler().Assemble(code); !bytes.Equal(a, e) {
+		t.Errorf("Expecting [%v] but got [%v]", e, a)
+	}
+}
+
+func TestParseGlobals(t *testing.T) {
+	code := testParse(t, ".global 1 b\n.global 0 a\n")
+	if a := code.Globals(); len(a) != 2 || a[0] != "a" || a[1] != "b" {
+		t.Errorf("Unexpected globals %v", a)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testParseError(t, "  Foo", "line 1: operation [Foo] undefined")
+	testParseError(t, "\n  Const", "line 2: [Const] requires [1] arguments but got [0]")
+	testParseError(t, "  Const x", "line 1: invalid argument [x]")
+	testParseError(t, "  Jump L0", "line 1: label [L0] undefined")
+	testParseError(t, "L0:\nL0:", "line 2: label [L0] already defined")
+	testParseError(t, "  String 'abc", "line 1: unterminated string")
+	testParseError(t, "  String abc", "line 1: invalid string [abc]")
+	testParseError(t, "  .pos a", "line 1: invalid source position [a]")
+	testParseError(t, "  .foo", "line 1: directive [.foo] undefined")
+}
+
+func testRoundTrip(t *testing.T, src string) {
+	t.Helper()
+	code := testParse(t, src)
+	if a := asm.NewAsmPrinter().PrintToStr(code); a != src {
+		t.Errorf("Expecting\n%s\nbut got\n%s", src, a)
+	}
+}
+
+func testParse(t *testing.T, src string) asm.AsmCode {
+	t.Helper()
+	code, err := asm.NewAsmParser().Parse(src)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	return code
+}
+
+func testParseError(t *testing.T, src string, e string) {
+	t.Helper()
+	_, err := asm.NewAsmParser().Parse(src)
+	if err == nil || !strings.Contains(err.Error(), e) {
+		t.Errorf("Expecting error [%s] but got [%v]", e, err)
+	}
+}
@@ -3,13 +3,19 @@ package asm
 import (
 	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/mhoertnagl/noodles/internal/vm"
 )
 
+// AsmPrinter prints assembly in the textual format that AsmParser reads.
+// Instructions that access global definitions are annotated with the names
+// of the globals declared before.
 type AsmPrinter struct {
-	code  AsmCode
-	lines []string
+	code    AsmCode
+	lines   []string
+	globals map[uint64]string
 }
 
 func NewAsmPrinter() *AsmPrinter {
@@ -18,6 +24,7 @@ func NewAsmPrinter() *AsmPrinter {
 
 func (m *AsmPrinter) Print(code AsmCode) []string {
 	m.lines = make([]string, 0)
+	m.globals = make(map[uint64]string)
 	for _, line := range code {
 		switch x := line.(type) {
 		case *AsmLabel:
@@ -29,7 +36,14 @@ func (m *AsmPrinter) Print(code AsmCode) []string {
 		case *AsmIns:
 			m.writeInstr(x)
 		case *AsmStr:
-			m.write("  %s '%s'", m.opName(vm.OpStr), x.Str)
+			m.write("  %s %s", m.opName(x.Op), quote(x.Str))
+		case *AsmSrcPos:
+			m.write("  .pos %s", x.Pos)
+		case *AsmFn:
+			m.write("  .fn %s %s %s %s", x.Name, x.Start, x.End, x.Pos)
+		case *AsmGlobal:
+			m.globals[x.ID] = x.Name
+			m.write(".global %d %s", x.ID, x.Name)
 		}
 	}
 	return m.lines
@@ -68,5 +82,19 @@ func (m *AsmPrinter) writeInstr(ins *AsmIns) {
 		buf.WriteString(" ")
 		buf.WriteString(fmt.Sprintf("%d", arg))
 	}
+	if ins.Op == vm.OpGetGlobal || ins.Op == vm.OpSetGlobal {
+		if name, ok := m.globals[ins.Args[0]]; ok {
+			buf.WriteString(" ; ")
+			buf.WriteString(name)
+		}
+	}
 	m.lines = append(m.lines, buf.String())
 }
+
+// quote encloses s in single quotes. Quotes, backslashes and non-printable
+// characters are escaped the way Go escapes them.
+func quote(s string) string {
+	q := strconv.Quote(s)
+	q = strings.ReplaceAll(q[1:len(q)-1], `\"`, `"`)
+	return "'" + strings.ReplaceAll(q, "'", `\'`) + "'"
+}
@@ -7,49 +7,61 @@ type Node interface {
 
 type ErrorNode struct {
 	Msg string
+	Pos Pos
 }
 
 func NewError(format string, args ...interface{}) *ErrorNode {
 	return &ErrorNode{Msg: fmt.Sprintf(format, args...)}
 }
 
+// Error returns the error message prefixed with its source position.
+func (e *ErrorNode) Error() string {
+	return FormatError(e.Pos, e.Msg)
+}
+
 func IsError(n Node) bool {
 	_, ok := n.(*ErrorNode)
 	return ok
 }
 
 func IsNil(n Node) bool {
-	return n == nil
+	_, ok := Lit(n).(Nil)
+	return ok
 }
 
 func IsBool(n Node) bool {
-	_, ok := n.(bool)
+	_, ok := Lit(n).(bool)
 	return ok
 }
 
 func IsInteger(n Node) bool {
-	_, ok := n.(int64)
+	_, ok := Lit(n).(int64)
 	return ok
 }
 
 func IsNumber(n Node) bool {
-	_, ok := n.(float64)
+	_, ok := Lit(n).(float64)
 	return ok
 }
 
 func IsString(n Node) bool {
-	_, ok := n.(string)
+	_, ok := Lit(n).(string)
 	return ok
 }
 
 type SymbolNode struct {
 	Name string
+	Pos  Pos
 }
 
 func NewSymbol(name string) *SymbolNode {
 	return &SymbolNode{Name: name}
 }
 
+func NewSymbolAt(name string, pos Pos) *SymbolNode {
+	return &SymbolNode{Name: name, Pos: pos}
+}
+
 func IsSymbol(n Node) bool {
 	_, ok := n.(*SymbolNode)
 	return ok
@@ -61,12 +73,17 @@ func (s *SymbolNode) String() string {
 
 type ListNode struct {
 	Items []Node
+	Pos   Pos
 }
 
 func NewList(items []Node) *ListNode {
 	return &ListNode{Items: items}
 }
 
+func NewListAt(items []Node, pos Pos) *ListNode {
+	return &ListNode{Items: items, Pos: pos}
+}
+
 func NewList2(items ...Node) *ListNode {
 	return &ListNode{Items: items}
 }
@@ -123,6 +140,10 @@ func Quote(n Node) *ListNode {
 	return Call("quote", n)
 }
 
+func Quasiquote(n Node) *ListNode {
+	return Call("quasiquote", n)
+}
+
 func Unquote(n Node) *ListNode {
 	return Call("unquote", n)
 }
@@ -132,7 +153,63 @@ func Dissolve(n Node) *ListNode {
 }
 
 func Fn(args []Node, body Node) *ListNode {
-	return NewList2(NewSymbol("fn"), args, body)
+	return NewList2(NewSymbol("fn"), NewVector(args), body)
+}
+
+// Nil is the nil literal. A Go nil is not a node. Rewriters return a Go nil
+// for nodes that they remove.
+type Nil struct{}
+
+// Keyword is a keyword literal such as :foo. The name does not include the
+// leading colon.
+type Keyword string
+
+// MapNode is a hash map literal. Keys and values alternate in source order.
+type MapNode struct {
+	Items []Node
+	Pos   Pos
+}
+
+func NewMapAt(items []Node, pos Pos) *MapNode {
+	return &MapNode{Items: items, Pos: pos}
 }
 
-type Map map[string]Node
+// VectorNode is a vector literal.
+type VectorNode struct {
+	Items []Node
+	Pos   Pos
+}
+
+func NewVector(items []Node) *VectorNode {
+	return &VectorNode{Items: items}
+}
+
+func NewVectorAt(items []Node, pos Pos) *VectorNode {
+	return &VectorNode{Items: items, Pos: pos}
+}
+
+func IsVector(n Node) bool {
+	_, ok := n.(*VectorNode)
+	return ok
+}
+
+// LitNode is a literal of the source code along with its position: nil, a
+// boolean, a number, a string or a keyword. Code that is created by the
+// compiler and the rewriters may use the bare values instead.
+type LitNode struct {
+	Val Node
+	Pos Pos
+}
+
+func NewLitAt(val Node, pos Pos) *LitNode {
+	return &LitNode{Val: val, Pos: pos}
+}
+
+// Lit returns the value of the literal n. Other nodes are returned as they
+// are.
+func Lit(n Node) Node {
+	if l, ok := n.(*LitNode); ok {
+		return l.Val
+	}
+	return n
+}
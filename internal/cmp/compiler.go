@@ -5,7 +5,6 @@ import (
 	"math"
 
 	"github.com/mhoertnagl/noodles/internal/asm"
-	"github.com/mhoertnagl/noodles/internal/util"
 	"github.com/mhoertnagl/noodles/internal/vm"
 )
 
@@ -21,9 +20,6 @@ import (
 // TODO: parse?
 
 // TODO: Should we keep let bindings?
-// TODO: let binding recursive functions - see TestCompileLet5.
-// TODO: let bindings should have their own symbol table.
-//       Fix Indexof to account for this fact.
 
 // TODO: Feed global names to disassembler and any place where they make sense.
 
@@ -40,6 +36,10 @@ type Compiler struct {
 	defs     *defMap
 	code     asm.AsmCode
 	lblId    int
+	pos      Pos
+	debug    bool
+	marked   Pos
+	fnName   string
 	err      []string
 }
 
@@ -56,15 +56,22 @@ func NewCompiler() *Compiler {
 	c.specs.add("-", c.compileSub)
 	c.specs.add("/", c.compileDiv)
 	c.specs.add("set", c.compileSet)
+	c.specs.add("set!", c.compileSetBang)
 	c.specs.add("let", c.compileLet)
+	c.specs.add("letrec", c.compileLetrec)
 	c.specs.add("def", c.compileDef)
 	c.specs.add("if", c.compileIf)
 	c.specs.add("cond", c.compileCond)
-	c.specs.add("do", c.compileNodes)
+	c.specs.add("do", c.compileDo)
 	c.specs.add("fn", c.compileFn)
 	c.specs.add("and", c.compileAnd)
 	c.specs.add("or", c.compileOr)
 	c.specs.add("rec", c.compileRec)
+	c.specs.add("try", c.compileTry)
+	c.specs.add("quasiquote", c.compileQuasiquote)
+	c.specs.add("swap!", c.compileSwap)
+	c.specs.add("loop", c.compileLoop)
+	c.specs.add("recur", c.compileRecur)
 
 	c.prims = primDefs{}
 	c.prims.add("nth", vm.OpNth, 2, false)
@@ -85,6 +92,26 @@ func NewCompiler() *Compiler {
 	c.prims.add("explode", vm.OpExplode, 1, false)
 	c.prims.add("runtime", vm.OpRuntime, 0, false)
 	c.prims.add("halt", vm.OpHalt, 0, false)
+	c.prims.add("contains?", vm.OpContains, 2, false)
+	c.prims.add("keys", vm.OpKeys, 1, false)
+	c.prims.add("vals", vm.OpVals, 1, false)
+	c.prims.add("count", vm.OpLength, 1, false)
+	c.prims.add("bool?", vm.OpIs, 1, false, vm.TypeBool)
+	c.prims.add("int?", vm.OpIs, 1, false, vm.TypeInt)
+	c.prims.add("float?", vm.OpIs, 1, false, vm.TypeFloat)
+	c.prims.add("str?", vm.OpIs, 1, false, vm.TypeStr)
+	c.prims.add("vec?", vm.OpIs, 1, false, vm.TypeVec)
+	c.prims.add("fn?", vm.OpIs, 1, false, vm.TypeFn)
+	c.prims.add("map?", vm.OpIs, 1, false, vm.TypeMap)
+	c.prims.add("keyword?", vm.OpIs, 1, false, vm.TypeKeyword)
+	c.prims.add("list?", vm.OpIs, 1, false, vm.TypeList)
+	c.prims.add("symbol?", vm.OpIs, 1, false, vm.TypeSymbol)
+	c.prims.add("atom?", vm.OpIs, 1, false, vm.TypeAtom)
+	c.prims.add("type-of", vm.OpTypeOf, 1, false)
+	c.prims.add("throw", vm.OpThrow, 1, false)
+	c.prims.add("atom", vm.OpAtom, 1, false)
+	c.prims.add("deref", vm.OpDeref, 1, false)
+	c.prims.add("reset!", vm.OpReset, 2, false)
 
 	c.varPrims = varPrimDefs{}
 	c.varPrims.add("+", vm.OpAdd, 0)
@@ -92,29 +119,81 @@ func NewCompiler() *Compiler {
 	c.varPrims.add("write", vm.OpWrite, 1)
 	c.varPrims.add("++", vm.OpConcat, 0)
 	c.varPrims.add("join", vm.OpJoin, 0)
+	c.varPrims.add("get", vm.OpGet, 2)
+	c.varPrims.add("assoc", vm.OpAssoc, 3)
+	c.varPrims.add("dissoc", vm.OpDissoc, 1)
 
 	return c
 }
 
+// EnableDebugInfo makes the compiler emit source positions and function
+// ranges along with the code. The assembler turns them into a debug table.
+func (c *Compiler) EnableDebugInfo() {
+	c.debug = true
+}
+
 func (c *Compiler) Errors() []string {
 	return c.err
 }
 
+// error records an error at the position of the innermost form that is
+// currently being compiled.
 func (c *Compiler) error(format string, args ...interface{}) {
-	e := fmt.Sprintf(format, args...)
+	c.errorAt(c.pos, format, args...)
+}
+
+func (c *Compiler) errorAt(pos Pos, format string, args ...interface{}) {
+	e := FormatError(pos, fmt.Sprintf(format, args...))
 	c.err = append(c.err, e)
 }
 
+// errorOn records an error at the position of the node n. Nodes without a
+// position fall back to the position of the current form.
+func (c *Compiler) errorOn(n Node, format string, args ...interface{}) {
+	if pos := PosOf(n); pos.IsValid() {
+		c.errorAt(pos, format, args...)
+	} else {
+		c.error(format, args...)
+	}
+}
+
+// enter makes pos the position of the form that is currently being compiled
+// and returns a function that restores the previous position.
+func (c *Compiler) enter(pos Pos) func() {
+	prev := c.pos
+	if pos.IsValid() {
+		c.pos = pos
+		c.mark(pos)
+	}
+	return func() {
+		c.pos = prev
+		c.mark(prev)
+	}
+}
+
+// mark emits a source position marker if debug information is enabled and the
+// position differs from the last one emitted.
+func (c *Compiler) mark(pos Pos) {
+	if c.debug && pos.IsValid() && pos != c.marked {
+		c.code = append(c.code, asm.SrcPos(pos.srcPos()))
+		c.marked = pos
+	}
+}
+
 func (c *Compiler) Compile(node Node) asm.AsmCode {
 	sym := NewSymTable()
+	sym.AddCells(cellNames(node)...)
 	ctx := NewCtx()
 	c.code = make(asm.AsmCode, 0)
+	c.marked = Pos{}
 	c.compile(node, sym, ctx)
 	return c.code
 }
 
 func (c *Compiler) compile(node Node, sym *SymTable, ctx *Ctx) {
 	switch n := node.(type) {
+	case Nil:
+		c.instr(vm.OpNil)
 	case bool:
 		if n {
 			c.instr(vm.OpTrue)
@@ -127,10 +206,16 @@ func (c *Compiler) compile(node Node, sym *SymTable, ctx *Ctx) {
 		c.instr(vm.OpConstF, math.Float64bits(n))
 	case string:
 		c.str(n)
+	case Keyword:
+		c.code = append(c.code, asm.Keyword(string(n)))
+	case *LitNode:
+		c.compile(n.Val, sym, ctx)
 	case *SymbolNode:
 		c.compileSymbol(n, sym, ctx)
-	case []Node:
-		c.compileVector(n, sym, ctx)
+	case *VectorNode:
+		c.compileVector(n.Items, sym, ctx)
+	case *MapNode:
+		c.compileMap(n, sym, ctx)
 	case *ListNode:
 		c.compileList(n, sym, ctx)
 	default:
@@ -144,6 +229,9 @@ func (c *Compiler) compileSymbol(n *SymbolNode, sym *SymTable, ctx *Ctx) {
 	// The symbol is locally bound. Load the bound value from the FRAMES stack.
 	if idx, ok := sym.IndexOf(n.Name); ok {
 		c.instr(vm.OpGetArg, uint64(idx))
+		if sym.IsCell(n.Name) {
+			c.instr(vm.OpGetCell)
+		}
 		return
 	}
 	// The symbol refers to a globally defined value. Load the value from the
@@ -152,8 +240,41 @@ func (c *Compiler) compileSymbol(n *SymbolNode, sym *SymTable, ctx *Ctx) {
 		c.instr(vm.OpGetGlobal, id)
 		return
 	}
+	// The symbol names a primitive function that is used as a value.
+	if c.compilePrimRef(n.Name, sym, ctx) {
+		return
+	}
 	// The symbol is neither a local argument nor a global value.
-	c.error("unknown symbol [%s]", n.Name)
+	c.errorAt(n.Pos, "unknown symbol [%s]", n.Name)
+}
+
+// compilePrimRef compiles a primitive function that is not called but used as
+// a value. The primitive is wrapped in a function that passes its arguments
+// on to the primitive.
+//
+//    <+> := <(fn [& xs] (+ @xs))>
+//    <-> := <(fn [x0 x1] (- x0 x1))>
+//
+func (c *Compiler) compilePrimRef(name string, sym *SymTable, ctx *Ctx) bool {
+	params := []Node{}
+	args := []Node{}
+	if prim, ok := c.prims[name]; ok {
+		for i := 0; i < prim.nargs; i++ {
+			params = append(params, NewSymbol(fmt.Sprintf("x%d", i)))
+		}
+		args = params
+	} else if _, ok := c.varPrims[name]; ok {
+		params = append(params, NewSymbol("&"), NewSymbol("xs"))
+		args = append(args, Dissolve(NewSymbol("xs")))
+	} else if name == "-" || name == "/" {
+		params = append(params, NewSymbol("x0"), NewSymbol("x1"))
+		args = params
+	} else {
+		return false
+	}
+	c.fnName = name
+	c.compileFn2([]fnClause{{params, CallVar(name, args...)}}, sym, ctx)
+	return true
 }
 
 // compileVector compiles a vector. If it is empty it will compile to a single
@@ -165,11 +286,30 @@ func (c *Compiler) compileVector(n []Node, sym *SymTable, ctx *Ctx) {
 		c.instr(vm.OpEmptyVector)
 	default:
 		c.instr(vm.OpEnd)
-		c.compileNodesReverse(n, sym, ctx)
+		c.compileNodesReverse(n, sym, ctx.NonTail())
 		c.instr(vm.OpList)
 	}
 }
 
+// compileMap compiles a hash map literal. The keys and values are compiled in
+// reverse order and bracketed in End and Map instructions.
+//
+//    <{k1 v1 ... kn vn}> :=
+//        End
+//        <vn>
+//        <kn>
+//        ...
+//        <v1>
+//        <k1>
+//        Map
+//
+func (c *Compiler) compileMap(n *MapNode, sym *SymTable, ctx *Ctx) {
+	defer c.enter(n.Pos)()
+	c.instr(vm.OpEnd)
+	c.compileNodesReverse(n.Items, sym, ctx.NonTail())
+	c.instr(vm.OpMap)
+}
+
 // compileList compiles a function invocation. An empty list will compile to an
 // EmptyVector instruction. If there is at leas a single element in the list
 // and the first element of the list is a symbol, it will be matched with the
@@ -178,10 +318,12 @@ func (c *Compiler) compileVector(n []Node, sym *SymTable, ctx *Ctx) {
 //   If the first element is itself a list we compile ths list beforehand. The
 // result of that list call is expected to yield a function reference.
 func (c *Compiler) compileList(n *ListNode, sym *SymTable, ctx *Ctx) {
+	defer c.enter(n.Pos)()
 	if n.Empty() {
 		c.instr(vm.OpEmptyVector)
+		return
 	}
-	switch x := n.First().(type) {
+	switch x := Lit(n.First()).(type) {
 	case *SymbolNode:
 		// Special forms handle their arguments in various ways. The arguments
 		// may not get compiled in sequence.
@@ -208,22 +350,54 @@ func (c *Compiler) compileList(n *ListNode, sym *SymTable, ctx *Ctx) {
 		// Compile the list. We expect the result of the computation to be a
 		// REF value which we can then call.
 		c.compileListCall(x, n.Rest(), sym, ctx)
+	case Keyword:
+		// The prelude used to define wrappers like :+ and :joinv for primitive
+		// functions. Calls to these keep working as calls to the wrapped
+		// function. In any other position a keyword is a keyword.
+		fn, ok := legacyFns[string(x)]
+		if !ok || !c.isFunctionName(fn, sym) {
+			c.errorOn(n.First(), "keyword [:%s] is not a function", x)
+			return
+		}
+		items := append([]Node{NewSymbolAt(fn, n.Pos)}, n.Rest()...)
+		c.compileList(NewListAt(items, n.Pos), sym, ctx)
 	default:
-		c.error("Cannot compile list head [%v:%T]", x, x)
+		c.errorOn(n.First(), "Cannot compile list head [%v:%T]", x, x)
 	}
 }
 
+// legacyFns maps the names of the wrappers like :+ and :joinv that the
+// prelude used to define to the functions they wrapped.
+var legacyFns = map[string]string{
+	"+": "+", "-": "-", "*": "*", "/": "/", "mod": "mod",
+	"<": "<", ">": ">", "<=": "<=", ">=": ">=", "=": "=", "!=": "!=",
+	"not": "not", ".+": ".+", "+.": "+.", "++": "++", "nth": "nth",
+	"drop": "drop", "len": "len", "@": "dissolve", "explode": "explode",
+	"join": "join", "joinv": "joinv",
+}
+
+// isFunctionName returns true if name is a primitive function or a local or
+// global binding.
+func (c *Compiler) isFunctionName(name string, sym *SymTable) bool {
+	_, isPrim := c.prims[name]
+	_, isVarPrim := c.varPrims[name]
+	_, isLocal := sym.IndexOf(name)
+	_, isGlobal := c.defs.get(name)
+	return isPrim || isVarPrim || isLocal || isGlobal || name == "-" || name == "/"
+}
+
 // compilePrim compiles primitive functions with an exact number of arguments.
 func (c *Compiler) compilePrim(prim primDef, args []Node, sym *SymTable, ctx *Ctx) {
 	if len(args) != prim.nargs {
 		c.error("[%s] requires exactly [%d] arguments", prim.name, prim.nargs)
 	}
+	ctx = ctx.NonTail()
 	if prim.rev {
 		c.compileNodesReverse(args, sym, ctx)
 	} else {
 		c.compileNodes(args, sym, ctx)
 	}
-	c.instr(prim.op)
+	c.instr(prim.op, prim.operands...)
 }
 
 // compileVarPrim compiles primitive functions with a variable number of
@@ -234,15 +408,16 @@ func (c *Compiler) compileVarPrim(prim varPrimDef, args []Node, sym *SymTable, c
 	}
 
 	c.instr(vm.OpEnd)
-	c.compileNodesReverse(args, sym, ctx)
+	c.compileNodesReverse(args, sym, ctx.NonTail())
 	c.instr(prim.op)
 }
 
 func (c *Compiler) compileDebug(args []Node, sym *SymTable, ctx *Ctx) {
-	c.instr(vm.OpDebug, uint64(args[0].(int64)))
+	c.instr(vm.OpDebug, uint64(Lit(args[0]).(int64)))
 }
 
 func (c *Compiler) compileSub(args []Node, sym *SymTable, ctx *Ctx) {
+	ctx = ctx.NonTail()
 	switch len(args) {
 	case 0:
 		// Empty difference (-) yields 0.
@@ -269,6 +444,7 @@ func (c *Compiler) compileSub(args []Node, sym *SymTable, ctx *Ctx) {
 }
 
 func (c *Compiler) compileDiv(args []Node, sym *SymTable, ctx *Ctx) {
+	ctx = ctx.NonTail()
 	switch len(args) {
 	case 0:
 		// Empty division (/) yields 1.
@@ -317,7 +493,7 @@ func (c *Compiler) compileAnd(args []Node, sym *SymTable, ctx *Ctx) {
 		lbl := c.newLbl()
 		end := c.newLbl()
 		for i := 0; i < len(args)-1; i++ {
-			c.compile(args[i], sym, ctx)
+			c.compile(args[i], sym, ctx.NonTail())
 			c.labeled(vm.OpJumpIfNot, lbl)
 		}
 		c.compile(args[len(args)-1], sym, ctx)
@@ -340,7 +516,7 @@ func (c *Compiler) compileOr(args []Node, sym *SymTable, ctx *Ctx) {
 		lbl := c.newLbl()
 		end := c.newLbl()
 		for i := 0; i < len(args)-1; i++ {
-			c.compile(args[i], sym, ctx)
+			c.compile(args[i], sym, ctx.NonTail())
 			c.labeled(vm.OpJumpIf, lbl)
 		}
 		c.compile(args[len(args)-1], sym, ctx)
@@ -358,7 +534,7 @@ func (c *Compiler) compileSet(args []Node, sym *SymTable, ctx *Ctx) {
 	}
 	s, ok := args[0].(*SymbolNode)
 	if !ok {
-		c.error("[set] requires first argument to be a symbol")
+		c.errorOn(args[0], "[set] requires first argument to be a symbol")
 		return
 	}
 
@@ -368,54 +544,444 @@ func (c *Compiler) compileSet(args []Node, sym *SymTable, ctx *Ctx) {
 	// n, _ := sym.IndexOf(s.Name)
 	// fmt.Printf("SET %s @ %d\n", s.Name, n)
 
-	c.compile(args[1], sym, ctx)
+	c.compile(args[1], sym, ctx.NonTail())
+	if sym.IsCell(s.Name) {
+		c.instr(vm.OpMakeCell)
+	}
 	c.instr(vm.OpPushArgs, 1)
 }
 
-func (c *Compiler) compileLet(args []Node, sym *SymTable, ctx *Ctx) {
+// compileSetBang compiles the assignment of a new value to an existing local
+// binding or global definition. Local bindings that are captured by closures
+// live in cells. The closures see the new value.
+//
+//    <(set! x v)> :=
+//        GetArg x
+//        <v>
+//        SetCell
+//
+func (c *Compiler) compileSetBang(args []Node, sym *SymTable, ctx *Ctx) {
 	if len(args) != 2 {
-		c.error("[let] requires exactly two arguments")
+		c.error("[set!] requires exactly two arguments")
+		return
+	}
+	s, ok := args[0].(*SymbolNode)
+	if !ok {
+		c.errorOn(args[0], "[set!] requires first argument to be a symbol")
+		return
+	}
+	if idx, ok := sym.IndexOf(s.Name); ok {
+		c.compileStore(idx, sym.IsCell(s.Name), args[1], sym, ctx)
+		return
+	}
+	if id, ok := c.defs.get(s.Name); ok {
+		c.compile(args[1], sym, ctx.NonTail())
+		c.instr(vm.OpSetGlobal, id)
 		return
 	}
+	c.errorAt(s.Pos, "[set!] unknown symbol [%s]", s.Name)
+}
 
-	bs, ok := args[0].(*ListNode)
+// compileStore compiles val and stores it in the slot idx of the frame or
+// in the cell that the slot holds.
+func (c *Compiler) compileStore(idx int, cell bool, val Node, sym *SymTable, ctx *Ctx) {
+	if cell {
+		c.instr(vm.OpGetArg, uint64(idx))
+		c.compile(val, sym, ctx.NonTail())
+		c.instr(vm.OpSetCell)
+		return
+	}
+	c.compile(val, sym, ctx.NonTail())
+	c.instr(vm.OpSetArg, uint64(idx))
+}
+
+// pushLocal binds the value on top of the stack to a new slot of scope.
+func (c *Compiler) pushLocal(name string, scope *SymTable) {
+	if scope.NeedsCell(name) {
+		c.instr(vm.OpMakeCell)
+	}
+	c.instr(vm.OpPushArgs, 1)
+	scope.AddVar(name)
+}
+
+// compileLet compiles let bindings. The bindings live in a scope of their
+// own that shadows the enclosing bindings. Each value sees the previous
+// bindings. A function sees its own binding as well and may call itself.
+// Its slot holds nil until the closure has been created. The closure is
+// patched afterwards to capture itself.
+//
+//    <(let (f (fn [n] (f n))) body)> :=
+//        Nil
+//        PushArgs 1
+//        <(fn [n] (f n))>
+//        SetArg 0
+//        GetArg 0
+//        GetArg 0
+//        PatchRef 0
+//        <body>
+//        DropArgs 1
+//
+func (c *Compiler) compileLet(args []Node, sym *SymTable, ctx *Ctx) {
+	names, vals, ok := c.verifyBindings("let", args)
 	if !ok {
-		c.error("[let] requires first argument to be a list of bindings")
 		return
 	}
-	if len(bs.Items)%2 == 1 {
-		c.error("[let] reqires an even number of bindings")
+
+	scope := sym.NewScope()
+	for i, name := range names {
+		if !c.capturesItself(name, vals[i], scope) {
+			c.compile(vals[i], scope, ctx.NonTail())
+			c.pushLocal(name, scope)
+			continue
+		}
+		slot := scope.frameSize()
+		c.instr(vm.OpNil)
+		c.pushLocal(name, scope)
+		c.compileStore(slot, scope.NeedsCell(name), vals[i], scope, ctx)
+		c.patchClosures(names[i:i+1], vals[i:i+1], []int{slot}, scope)
+	}
+	c.compileScopeBody(args[1], scope, ctx)
+}
+
+// compileLetrec compiles let bindings that are all visible to all values.
+// Functions may thus be mutually recursive. The slots hold nil until the
+// values have been computed. Closures are patched afterwards to capture the
+// final values.
+func (c *Compiler) compileLetrec(args []Node, sym *SymTable, ctx *Ctx) {
+	names, vals, ok := c.verifyBindings("letrec", args)
+	if !ok {
 		return
 	}
 
-	// TODO: separate symbol table would be better.
-	// TODO: Problem when shadowing a variable.
-	locals := make([]string, 0)
+	scope := sym.NewScope()
+	slots := make([]int, len(names))
+	for i, name := range names {
+		slots[i] = scope.frameSize()
+		c.instr(vm.OpNil)
+		c.pushLocal(name, scope)
+	}
+	for i, val := range vals {
+		c.compileStore(slots[i], scope.NeedsCell(names[i]), val, scope, ctx)
+	}
+	c.patchClosures(names, vals, slots, scope)
+	c.compileScopeBody(args[1], scope, ctx)
+}
+
+// compileLoop compiles a loop. The loop bindings are let bindings. The body
+// is in tail position with respect to the loop. A recur in the body assigns
+// new values to the loop bindings and jumps back to the head of the loop.
+//
+//    <(loop (i 0) (if (< i 9) (recur (+ i 1)) i))> :=
+//        Const 0
+//        PushArgs 1
+//    L0: <(if (< i 9) (recur (+ i 1)) i)>
+//        DropArgs 1
+//
+func (c *Compiler) compileLoop(args []Node, sym *SymTable, ctx *Ctx) {
+	if len(args) == 2 {
+		// Accept bindings either as (a x b y) or as [a x b y].
+		if bs, ok := args[0].(*VectorNode); ok {
+			args = []Node{NewListAt(bs.Items, bs.Pos), args[1]}
+		}
+	}
+	names, vals, ok := c.verifyBindings("loop", args)
+	if !ok {
+		return
+	}
+
+	scope := sym.NewScope()
+	for i, name := range names {
+		c.compile(vals[i], scope, ctx.NonTail())
+		c.pushLocal(name, scope)
+	}
+	loop := &Loop{
+		Label: c.newLbl(),
+		Names: names,
+		Scope: scope,
+		Tail:  ctx.TailCall(),
+	}
+	c.label(loop.Label)
+	c.compile(args[1], scope, ctx.NewLoopCtx(loop))
+	c.instr(vm.OpDropArgs, uint64(scope.Size()))
+}
+
+// compileRecur compiles a jump back to the head of the innermost loop. All
+// values are computed before they are assigned to the loop bindings. Let
+// bindings in the loop body are dropped.
+//
+//    <(recur x y)> :=
+//        <x>
+//        <y>
+//        SetArg b
+//        SetArg a
+//        Jump L0
+//
+func (c *Compiler) compileRecur(args []Node, sym *SymTable, ctx *Ctx) {
+	loop := ctx.Loop
+	if loop == nil {
+		c.error("[recur] is not in a loop")
+		return
+	}
+	if !ctx.Tail {
+		c.error("[recur] is not in tail position")
+		return
+	}
+	if len(args) != len(loop.Names) {
+		c.error("[recur] requires [%d] arguments but got [%d]", len(loop.Names), len(args))
+		return
+	}
+	for i, arg := range args {
+		c.compile(arg, sym, ctx.NonTail())
+		// Every iteration gets fresh cells. Closures of previous iterations keep
+		// their cells.
+		if loop.Scope.NeedsCell(loop.Names[i]) {
+			c.instr(vm.OpMakeCell)
+		}
+	}
+	for i := len(loop.Names) - 1; i >= 0; i-- {
+		c.instr(vm.OpSetArg, uint64(loop.Scope.base+i))
+	}
+	if n := sym.frameSize() - loop.Scope.frameSize(); n > 0 {
+		c.instr(vm.OpDropArgs, uint64(n))
+	}
+	c.labeled(vm.OpJump, loop.Label)
+}
+
+// verifyBindings returns the names and values of the bindings of a let form.
+func (c *Compiler) verifyBindings(form string, args []Node) ([]string, []Node, bool) {
+	if len(args) != 2 {
+		c.error("[%s] requires exactly two arguments", form)
+		return nil, nil, false
+	}
+	bs, ok := args[0].(*ListNode)
+	if !ok {
+		c.errorOn(args[0], "[%s] requires first argument to be a list of bindings", form)
+		return nil, nil, false
+	}
+	if len(bs.Items)%2 == 1 {
+		c.error("[%s] reqires an even number of bindings", form)
+		return nil, nil, false
+	}
+	names := []string{}
+	vals := []Node{}
 	for i := 0; i < len(bs.Items); i += 2 {
 		s, ok := bs.Items[i].(*SymbolNode)
 		if !ok {
-			c.error(fmt.Sprintf("[let] cannot bind to [%v]", s))
-			return
+			c.errorOn(bs.Items[i], "[%s] cannot bind to [%v]", form, PrintAst(bs.Items[i]))
+			return nil, nil, false
 		}
-		// Keep track of the let bindings. We will remove them when we fall
-		// out of scope.
-		locals = append(locals, s.Name)
-		// Add the local binding to the symbol table.
-		sym.AddVar(s.Name)
-
-		c.compile(bs.Items[i+1], sym, ctx)
-		// Add the let bindings one at a time so that subsequent bindings
-		// will be able to access the privously defined let bindings.
-		c.instr(vm.OpPushArgs, 1)
+		names = append(names, s.Name)
+		vals = append(vals, bs.Items[i+1])
 	}
+	return names, vals, true
+}
 
-	c.compile(args[1], sym, ctx)
+// compileScopeBody compiles the body of a let form and drops the slots of its
+// scope afterwards.
+func (c *Compiler) compileScopeBody(body Node, scope *SymTable, ctx *Ctx) {
+	// The body is in tail position if the let expression is. A tail call drops
+	// the let bindings along with the frame.
+	c.compile(body, scope, ctx)
 	// A let binding does not posess a separate frame. We need to drop all
-	// introduced let bindings before we continue.
-	c.instr(vm.OpDropArgs, uint64(len(locals)))
+	// introduced let bindings before we continue. This includes the bindings
+	// that have been introduced by set in the body.
+	c.instr(vm.OpDropArgs, uint64(scope.Size()))
+}
+
+// capturesItself returns true if val is a function that refers to the
+// binding name.
+func (c *Compiler) capturesItself(name string, val Node, scope *SymTable) bool {
+	sub := scope.NewScope()
+	sub.AddVar(name)
+	eps, ok := c.fnClosureParams(val, sub)
+	return ok && !notContainsSymbol(eps, NewSymbol(name))
+}
+
+// patchClosures patches the functions among vals to capture the current
+// values of the slots. The functions have captured these slots before they
+// were initialized. Slots that hold cells need no patching because the
+// functions have captured the cells.
+func (c *Compiler) patchClosures(names []string, vals []Node, slots []int, scope *SymTable) {
+	for i, val := range vals {
+		eps, ok := c.fnClosureParams(val, scope)
+		if !ok {
+			continue
+		}
+		for k, ep := range eps {
+			name := ep.(*SymbolNode).Name
+			idx, _ := scope.IndexOf(name)
+			if !containsSlot(slots, idx) || scope.IsCell(name) {
+				continue
+			}
+			c.instr(vm.OpGetArg, uint64(slots[i]))
+			if scope.NeedsCell(names[i]) {
+				c.instr(vm.OpGetCell)
+			}
+			c.instr(vm.OpGetArg, uint64(idx))
+			c.instr(vm.OpPatchRef, uint64(k))
+		}
+	}
+}
+
+// compileTry compiles a try block. The handler is active while the body is
+// evaluated. If the body throws, the stacks are unwound and the handler is
+// evaluated with the exception bound to the symbol of the catch clause.
+//
+//    <(try body (catch e handler))> :=
+//        Try L0
+//        <body>
+//        EndTry
+//        Jump L1
+//    L0: PushArgs 1
+//        <handler>
+//        DropArgs 1
+//    L1:
+//
+func (c *Compiler) compileTry(args []Node, sym *SymTable, ctx *Ctx) {
+	if len(args) != 2 {
+		c.error("[try] requires exactly two arguments")
+		return
+	}
+	clause, ok := args[1].(*ListNode)
+	if !ok || clause.Len() != 3 || !IsCall(clause, "catch") {
+		c.error("[try] requires second argument to be a catch clause")
+		return
+	}
+	e, ok := clause.Items[1].(*SymbolNode)
+	if !ok {
+		c.errorOn(clause.Items[1], "[catch] cannot bind to [%v]", PrintAst(clause.Items[1]))
+		return
+	}
+
+	handler := c.newLbl()
+	end := c.newLbl()
+	c.labeled(vm.OpTry, handler)
+	// The body is never in tail position. A tail call would leave the try
+	// block without removing the handler.
+	c.compile(args[0], sym, ctx.NonTail())
+	c.instr(vm.OpEndTry)
+	c.labeled(vm.OpJump, end)
+	c.label(handler)
+	// The machine pushes the exception onto the stack. Bind it like a let
+	// binding.
+	scope := sym.NewScope()
+	c.pushLocal(e.Name, scope)
+	c.compileScopeBody(clause.Items[2], scope, ctx)
+	c.label(end)
+}
+
+// swapAtom is the name of the binding that holds the atom while swap! calls
+// the function. It cannot be written in source code.
+const swapAtom = " atom"
+
+// compileSwap compiles an update of an atom. The function is called with the
+// current value of the atom and the remaining arguments. Its result is the
+// new value of the atom. The atom is evaluated once and bound like a let
+// binding.
+//
+//    <(swap! a f x)> :=
+//        <a>
+//        PushArgs 1
+//        GetArg a
+//        End
+//        <x>
+//        GetArg a
+//        Deref
+//        <f>
+//        Call
+//        Reset
+//        DropArgs 1
+//
+func (c *Compiler) compileSwap(args []Node, sym *SymTable, ctx *Ctx) {
+	if len(args) < 2 {
+		c.error("[swap!] requires at least two arguments")
+		return
+	}
+	ctx = ctx.NonTail()
+	c.compile(args[0], sym, ctx)
+	scope := sym.NewScope()
+	c.pushLocal(swapAtom, scope)
+	idx, _ := scope.IndexOf(swapAtom)
+	c.instr(vm.OpGetArg, uint64(idx))
+	c.instr(vm.OpEnd)
+	c.compileNodesReverse(args[2:], scope, ctx)
+	c.instr(vm.OpGetArg, uint64(idx))
+	c.instr(vm.OpDeref)
+	c.compile(args[1], scope, ctx)
+	c.instr(vm.OpCall)
+	c.instr(vm.OpReset)
+	c.instr(vm.OpDropArgs, uint64(scope.Size()))
+}
 
-	// Remove the let bindings from the symbol table as well.
-	sym.Remove(locals)
+// compileQuasiquote compiles a quasi-quoted form into code that creates the
+// form as data. Symbols become symbol values and lists become list values.
+// Unquoted forms are evaluated and their values are inserted. Unquoted forms
+// that are dissolved insert all items of the resulting sequence.
+//
+//    <`(a ~b ~@c)> :=
+//        End
+//        <c>
+//        Dissolve
+//        <b>
+//        Symbol 'a'
+//        MakeList
+//
+func (c *Compiler) compileQuasiquote(args []Node, sym *SymTable, ctx *Ctx) {
+	if len(args) != 1 {
+		c.error("[quasiquote] requires exactly one argument")
+		return
+	}
+	c.compileQuasi(args[0], sym, ctx.NonTail())
+}
+
+func (c *Compiler) compileQuasi(n Node, sym *SymTable, ctx *Ctx) {
+	switch x := n.(type) {
+	case *SymbolNode:
+		c.code = append(c.code, asm.Symbol(x.Name))
+	case *ListNode:
+		if IsCall(x, "unquote") {
+			if x.Len() != 2 {
+				c.errorAt(x.Pos, "[unquote] requires exactly one argument")
+				return
+			}
+			c.compile(x.Items[1], sym, ctx)
+			return
+		}
+		c.compileQuasiSeq(x.Items, vm.OpMakeList, sym, ctx)
+	case *VectorNode:
+		c.compileQuasiSeq(x.Items, vm.OpList, sym, ctx)
+	case *MapNode:
+		c.instr(vm.OpEnd)
+		for i := len(x.Items) - 1; i >= 0; i-- {
+			c.compileQuasi(x.Items[i], sym, ctx)
+		}
+		c.instr(vm.OpMap)
+	default:
+		c.compile(n, sym, ctx)
+	}
+}
+
+func (c *Compiler) compileQuasiSeq(items []Node, op vm.Op, sym *SymTable, ctx *Ctx) {
+	c.instr(vm.OpEnd)
+	for i := len(items) - 1; i >= 0; i-- {
+		if e, ok := unquoteSplicing(items[i]); ok {
+			c.compile(e, sym, ctx)
+			c.instr(vm.OpDissolve)
+		} else {
+			c.compileQuasi(items[i], sym, ctx)
+		}
+	}
+	c.instr(op)
+}
+
+// unquoteSplicing returns the form x of the node ~@x.
+func unquoteSplicing(n Node) (Node, bool) {
+	if u, ok := n.(*ListNode); ok && IsCall(u, "unquote") && u.Len() == 2 {
+		if d, ok := u.Items[1].(*ListNode); ok && IsCall(d, "dissolve") && d.Len() == 2 {
+			return d.Items[1], true
+		}
+	}
+	return nil, false
 }
 
 // compileDef compiles a global definition. Global definitions will be bound in
@@ -434,15 +1000,19 @@ func (c *Compiler) compileDef(args []Node, sym *SymTable, ctx *Ctx) {
 
 	s, ok := args[0].(*SymbolNode)
 	if !ok {
-		c.error("[def] requires first argument to be a symbol")
+		c.errorOn(args[0], "[def] requires first argument to be a symbol")
 		return
 	}
 	// Assing a new ID to the definition name. It's required to do this before
 	// compiling the body of the definition in order to make the symbol available
 	// to recursive function calls.
 	id := c.defs.getOrAdd(s.Name)
+	// Name the function for the debug information.
+	if IsCallN(args[1], "fn") {
+		c.fnName = s.Name
+	}
 
-	c.compile(args[1], sym, ctx)
+	c.compile(args[1], sym, ctx.NonTail())
 	c.instr(vm.OpSetGlobal, id)
 }
 
@@ -470,14 +1040,14 @@ func (c *Compiler) compileIf(args []Node, sym *SymTable, ctx *Ctx) {
 	switch len(args) {
 	case 2:
 		end := c.newLbl()
-		c.compile(args[0], sym, ctx)
+		c.compile(args[0], sym, ctx.NonTail())
 		c.labeled(vm.OpJumpIfNot, end)
 		c.compile(args[1], sym, ctx)
 		c.label(end)
 	case 3:
 		alt := c.newLbl()
 		end := c.newLbl()
-		c.compile(args[0], sym, ctx)
+		c.compile(args[0], sym, ctx.NonTail())
 		c.labeled(vm.OpJumpIfNot, alt)
 		c.compile(args[1], sym, ctx)
 		c.labeled(vm.OpJump, end)
@@ -517,116 +1087,450 @@ func (c *Compiler) compileCond(args []Node, sym *SymTable, ctx *Ctx) {
 
 	for i := 0; i < len-2; i += 2 {
 		nxt := c.newLbl()
-		c.compile(args[i], sym, ctx)
+		c.compile(args[i], sym, ctx.NonTail())
 		c.labeled(vm.OpJumpIfNot, nxt)
 		c.compile(args[i+1], sym, ctx)
 		c.labeled(vm.OpJump, end)
 		c.label(nxt)
 	}
 	if len >= 2 {
-		c.compile(args[len-2], sym, ctx)
+		c.compile(args[len-2], sym, ctx.NonTail())
 		c.labeled(vm.OpJumpIfNot, end)
 		c.compile(args[len-1], sym, ctx)
 		c.label(end)
 	}
 }
 
+// fnClause is a parameter list and the body of a function. A function may
+// consist of several clauses that accept different numbers of arguments.
+type fnClause struct {
+	params []Node
+	body   Node
+}
+
+// fnParams are the mandatory parameters, the parameters with default values
+// and the rest parameter of a function clause.
+type fnParams struct {
+	man  []string
+	defs []defParam
+	rest string
+}
+
+// defParam is a parameter with a default value. It is written as
+// (name value) in the parameter list.
+type defParam struct {
+	name string
+	val  Node
+}
+
+// arity returns the minimum and the maximum number of arguments. The maximum
+// is vm.Variadic if there is a rest parameter.
+func (p fnParams) arity() (int, int) {
+	min := len(p.man)
+	if p.rest != "" {
+		return min, vm.Variadic
+	}
+	return min, min + len(p.defs)
+}
+
+func (p fnParams) accepts(n int) bool {
+	min, max := p.arity()
+	return n >= min && (max == vm.Variadic || n <= max)
+}
+
+// compileFn compiles a function. A function is either a parameter list
+// followed by the body or a list of clauses ([params] body). The clause is
+// selected by the number of arguments when the function is called.
+//
+//    (fn [a (b 1)] (+ a b))
+//    (fn ([a] a) ([a b] (+ a b)))
 func (c *Compiler) compileFn(args []Node, sym *SymTable, ctx *Ctx) {
-	if len(args) != 2 {
-		c.error("[fn] expects exactly 2 arguments")
+	clauses, ok := fnClauses(args)
+	if !ok {
+		c.error("[fn] expects a parameter list and a body or clauses ([params] body)")
 		return
 	}
-	// Accept parameter lists either as (a1 a2 ..) or as [a1 a2 ...] though it
-	// is customary to use the second notational form through out.
-	switch x := args[0].(type) {
-	case *ListNode:
-		c.compileFn2(x.Items, args[1], sym, ctx)
-	case []Node:
-		c.compileFn2(x, args[1], sym, ctx)
-	default:
-		c.error("[fn] first argument must be a list or vector")
+	c.compileFn2(clauses, sym, ctx)
+}
+
+// fnClauses returns the clauses of a function with the arguments args. A body
+// of several expressions is wrapped in a do block.
+func fnClauses(args []Node) ([]fnClause, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+	if params, ok := paramList(args[0]); ok {
+		return []fnClause{{params, fnBody(args[1:])}}, true
+	}
+	clauses := []fnClause{}
+	for _, arg := range args {
+		clause, ok := arg.(*ListNode)
+		if !ok || clause.Len() == 0 {
+			return nil, false
+		}
+		params, ok := clause.Items[0].(*VectorNode)
+		if !ok {
+			return nil, false
+		}
+		clauses = append(clauses, fnClause{params.Items, fnBody(clause.Items[1:])})
 	}
+	return clauses, true
 }
 
-func (c *Compiler) compileFn2(params []Node, body Node, sym *SymTable, ctx *Ctx) {
-	// fmt.Printf("OLD PARAMS %v\n", params)
-	// fmt.Println(sym)
-	// Find all closure parameters.
-	eps := c.listClosureParamsForSub(params, body, sym)
-	// Prepend closure paramesters.
-	newParams := []Node{}
-	newParams = append(newParams, eps...)
-	newParams = append(newParams, params...)
+// paramList returns the parameters if n is a parameter list. Accept parameter
+// lists either as (a1 a2 ..) or as [a1 a2 ...] though it is customary to use
+// the second notational form through out. A list that starts with a vector is
+// a clause.
+func paramList(n Node) ([]Node, bool) {
+	switch x := n.(type) {
+	case *VectorNode:
+		return x.Items, true
+	case *ListNode:
+		if x.Len() > 0 && IsVector(x.Items[0]) {
+			return nil, false
+		}
+		return x.Items, true
+	}
+	return nil, false
+}
 
-	// fmt.Printf("NEW PARAMS %v\n", newParams)
+func fnBody(nodes []Node) Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return CallVar("do", nodes...)
+}
 
-	sub := sym.NewSymTable()
+// compileFn2 compiles the clauses of a function. All clauses share the
+// closure parameters. The function checks the number of arguments and jumps
+// to the first clause that accepts them. Only a function that accepts any
+// number of arguments skips the check.
+//
+//    <(fn ([a] a) ([a b & c] b))> :=
+//        Jump L0
+//    L1: Argc 0
+//        Const 1
+//        EQ
+//        JumpIf L2
+//        Const 2
+//        Argc 0
+//        LE
+//        JumpIf L3
+//        String 'fn'
+//        ArityError 0
+//    L2: <[a] a>
+//    L3: <[a b & c] b>
+//    L0: Ref 0 L1
+//
+func (c *Compiler) compileFn2(clauses []fnClause, sym *SymTable, ctx *Ctx) {
+	pss := make([]fnParams, len(clauses))
+	for i, clause := range clauses {
+		pss[i] = c.extractParams(clause.params)
+	}
+	c.verifyArities(pss)
+	// Find all closure parameters.
+	eps := c.closureParams(pss, clauses, sym)
 
 	skp := c.newLbl()
 	fen := c.newLbl()
+	// Functions that are not bound by a global definition are anonymous.
+	name := c.fnName
+	c.fnName = ""
+	if name == "" {
+		name = "fn"
+	}
+	if c.debug {
+		c.code = append(c.code, asm.Fn(name, fen, skp, c.pos.srcPos()))
+	}
 	// Compiles the function body in-place.
 	// Jump over the function implementation.
 	c.labeled(vm.OpJump, skp)
 	// The function entry point.
-	// fmt.Println("BODY")
 	c.label(fen)
-	// Compile the acutal function code.
-	c.compileFnBody(newParams, body, sub, ctx)
+	if min, max := pss[0].arity(); len(clauses) == 1 && min == 0 && max == vm.Variadic {
+		c.compileClause(pss[0], clauses[0].body, eps, sym)
+	} else {
+		lbls := make([]string, len(clauses))
+		for i, ps := range pss {
+			lbls[i] = c.newLbl()
+			c.compileArityCheck(ps, len(eps), lbls[i])
+		}
+		c.str(name)
+		c.instr(vm.OpArityError, uint64(len(eps)))
+		for i, ps := range pss {
+			c.label(lbls[i])
+			c.compileClause(ps, clauses[i].body, eps, sym)
+		}
+	}
 	// This marks the end of the function.
-	// fmt.Println("BODY END")
 	c.label(skp)
 	// Push the extern arguments on the stack for the closure.
-	// fmt.Println("CLOSURE CALL")
+	// Cells are captured rather than their values.
 	for _, ep := range eps {
-		c.compileSymbol(ep.(*SymbolNode), sym, ctx)
+		idx, _ := sym.IndexOf(ep.(*SymbolNode).Name)
+		c.instr(vm.OpGetArg, uint64(idx))
 	}
-	// fmt.Println("CLOSURE CALL END")
 	// Return a closure to the function.
 	c.ref(len(eps), fen)
 }
 
-func (c *Compiler) compileFnBody(params []Node, body Node, sym *SymTable, ctx *Ctx) {
-	switch len(params) {
-	case 0:
+// compileArityCheck jumps to lbl if the clause accepts the arguments. The
+// arguments are below the cargs closure arguments.
+func (c *Compiler) compileArityCheck(ps fnParams, cargs int, lbl string) {
+	min, max := ps.arity()
+	if min == max {
+		c.instr(vm.OpArgc, uint64(cargs))
+		c.instr(vm.OpConst, uint64(min))
+		c.instr(vm.OpEQ)
+		c.labeled(vm.OpJumpIf, lbl)
+		return
+	}
+	// Argc has to come first. It counts the values below the top of the
+	// stack.
+	c.instr(vm.OpArgc, uint64(cargs))
+	c.instr(vm.OpConst, uint64(min))
+	c.instr(vm.OpLT)
+	if max == vm.Variadic {
+		c.labeled(vm.OpJumpIfNot, lbl)
+		return
+	}
+	nxt := c.newLbl()
+	c.labeled(vm.OpJumpIf, nxt)
+	c.instr(vm.OpArgc, uint64(cargs))
+	c.instr(vm.OpConst, uint64(max))
+	c.instr(vm.OpLE)
+	c.labeled(vm.OpJumpIf, lbl)
+	c.label(nxt)
+}
+
+// verifyArities reports clauses that accept the same number of arguments.
+// Two clauses overlap if both accept the larger of their minimum arities.
+func (c *Compiler) verifyArities(pss []fnParams) {
+	for i := range pss {
+		for j := 0; j < i; j++ {
+			n, _ := pss[i].arity()
+			if m, _ := pss[j].arity(); m > n {
+				n = m
+			}
+			if pss[i].accepts(n) && pss[j].accepts(n) {
+				c.error("[fn] clauses [%d] and [%d] accept [%d] arguments", j+1, i+1, n)
+			}
+		}
+	}
+}
+
+// compileClause compiles a clause of a function. The closure parameters
+// precede the parameters of the clause.
+func (c *Compiler) compileClause(ps fnParams, body Node, eps []Node, sym *SymTable) {
+	sub := sym.NewSymTable()
+	// Parameters that are captured by closures in the body and mutated live in
+	// cells. So do the closure parameters that are cells outside.
+	sub.AddCells(cellNames(CallVar("do", append(ps.defVals(), body)...))...)
+	names := []string{}
+	for _, ep := range eps {
+		name := ep.(*SymbolNode).Name
+		if sym.IsCell(name) {
+			sub.AddCells(name)
+		}
+		names = append(names, name)
+	}
+	ps.man = append(names, ps.man...)
+	// Compile the acutal function code. The body is in tail position.
+	c.compileFnBody(ps, len(eps), body, sub, NewCtx().NewTailCtx(true))
+}
+
+func (p fnParams) defVals() []Node {
+	vals := []Node{}
+	for _, def := range p.defs {
+		vals = append(vals, def.val)
+	}
+	return vals
+}
+
+// compileFnBody compiles the body of a function. The first cargs parameters
+// are closure parameters.
+func (c *Compiler) compileFnBody(ps fnParams, cargs int, body Node, sym *SymTable, ctx *Ctx) {
+	if len(ps.man) == 0 && len(ps.defs) == 0 && ps.rest == "" {
 		// Removes the function argument's end marker from the stack.
 		c.instr(vm.OpPop)
 		c.compile(body, sym, ctx)
 		c.instr(vm.OpReturn)
-	default:
-		man, opt := c.extractParams(params)
-		// Add the mandatory arguments to the local symbol table.
-		sym.Add(man)
-		// Push the mandatory arguments to the frames stack.
-		c.instr(vm.OpPushArgs, uint64(len(man)))
-		// Check for an optional argument.
-		if opt != "" {
-			// Add the optional argument to the local symbol table.
-			sym.AddVar(opt)
-			// The LIST operation will append all remaining arguments to a vector.
-			c.instr(vm.OpList)
-			// Then push the vector to the frames stack as well.
-			c.instr(vm.OpPushArgs, 1)
-		} else {
-			// Removes the function argument's end marker from the stack.
-			c.instr(vm.OpPop)
+		return
+	}
+	// Add the mandatory arguments to the local symbol table.
+	sym.Add(ps.man)
+	// Push the mandatory arguments to the frames stack.
+	c.instr(vm.OpPushArgs, uint64(len(ps.man)))
+	// Put the arguments that live in cells into cells. Closure parameters
+	// are cells already.
+	for _, n := range ps.man[cargs:] {
+		if sym.IsCell(n) {
+			idx, _ := sym.IndexOf(n)
+			c.instr(vm.OpGetArg, uint64(idx))
+			c.instr(vm.OpMakeCell)
+			c.instr(vm.OpSetArg, uint64(idx))
+		}
+	}
+	c.compileDefaults(ps.defs, sym, ctx)
+	// Check for an optional argument.
+	if ps.rest != "" {
+		// The LIST operation will append all remaining arguments to a vector.
+		c.instr(vm.OpList)
+		// Then push the vector to the frames stack as well. Add the optional
+		// argument to the local symbol table.
+		c.pushLocal(ps.rest, sym)
+	} else {
+		// Removes the function argument's end marker from the stack.
+		c.instr(vm.OpPop)
+	}
+	// Compile the body with this closure context.
+	c.compile(body, sym, ctx)
+	c.instr(vm.OpReturn)
+}
+
+// compileDefaults pushes the parameters with default values. Parameters
+// take the remaining arguments as long as there are any. The others take
+// their default values. A default value sees the preceding parameters.
+//
+//    <[(a x) (b y)]> :=
+//        Argc 0
+//        Const 0
+//        EQ
+//        JumpIf L0
+//        PushArgs 1
+//        Argc 0
+//        Const 0
+//        EQ
+//        JumpIf L1
+//        PushArgs 1
+//        Jump L2
+//    L0: <x>
+//        PushArgs 1
+//    L1: <y>
+//        PushArgs 1
+//    L2:
+//
+func (c *Compiler) compileDefaults(defs []defParam, sym *SymTable, ctx *Ctx) {
+	if len(defs) == 0 {
+		return
+	}
+	lbls := make([]string, len(defs))
+	for i, def := range defs {
+		lbls[i] = c.newLbl()
+		c.instr(vm.OpArgc, 0)
+		c.instr(vm.OpConst, 0)
+		c.instr(vm.OpEQ)
+		c.labeled(vm.OpJumpIf, lbls[i])
+		if sym.NeedsCell(def.name) {
+			c.instr(vm.OpMakeCell)
+		}
+		c.instr(vm.OpPushArgs, 1)
+	}
+	end := c.newLbl()
+	c.labeled(vm.OpJump, end)
+	for i, def := range defs {
+		c.label(lbls[i])
+		c.compile(def.val, sym, ctx.NonTail())
+		c.pushLocal(def.name, sym)
+	}
+	c.label(end)
+}
+
+// fnClosureParams returns the closure parameters of n if n is a function
+// literal.
+func (c *Compiler) fnClosureParams(n Node, sym *SymTable) ([]Node, bool) {
+	fn, ok := n.(*ListNode)
+	if !ok || !IsCall(fn, "fn") {
+		return nil, false
+	}
+	clauses, ok := fnClauses(fn.Items[1:])
+	if !ok {
+		return nil, false
+	}
+	pss := make([]fnParams, len(clauses))
+	for i, clause := range clauses {
+		pss[i] = c.extractParams(clause.params)
+	}
+	return c.closureParams(pss, clauses, sym), true
+}
+
+// closureParams returns the closure parameters of all clauses.
+func (c *Compiler) closureParams(pss []fnParams, clauses []fnClause, sym *SymTable) []Node {
+	eps := []Node{}
+	for i, clause := range clauses {
+		eps = mergeSymbols(eps, c.listClosureParamsForSub(pss[i], clause.body, sym))
+	}
+	return eps
+}
+
+func mergeSymbols(res []Node, syms []Node) []Node {
+	for _, s := range syms {
+		if notContainsSymbol(res, s.(*SymbolNode)) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// cellNames returns the names of the bindings in body that live in cells.
+// These are the names that are assigned with set! and occur in a nested
+// function. Shadowing is ignored. Additional cells do no harm.
+func cellNames(body Node) []string {
+	assigned := map[string]bool{}
+	nested := map[string]bool{}
+	collectCellNames(body, false, assigned, nested)
+	names := []string{}
+	for name := range assigned {
+		if nested[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func collectCellNames(n Node, inFn bool, assigned map[string]bool, nested map[string]bool) {
+	switch x := n.(type) {
+	case *SymbolNode:
+		if inFn {
+			nested[x.Name] = true
+		}
+	case *VectorNode:
+		for _, item := range x.Items {
+			collectCellNames(item, inFn, assigned, nested)
+		}
+	case *MapNode:
+		for _, item := range x.Items {
+			collectCellNames(item, inFn, assigned, nested)
+		}
+	case *ListNode:
+		if IsCall(x, "set!") && x.Len() == 3 {
+			if s, ok := x.Items[1].(*SymbolNode); ok {
+				assigned[s.Name] = true
+			}
+		}
+		inFn = inFn || IsCall(x, "fn")
+		for _, item := range x.Items {
+			collectCellNames(item, inFn, assigned, nested)
 		}
-		// Compile the body with this closure context.
-		c.compile(body, sym, ctx)
-		c.instr(vm.OpReturn)
 	}
 }
 
-func (c *Compiler) listClosureParamsForSub(params []Node, node Node, sym *SymTable) []Node {
+func (c *Compiler) listClosureParamsForSub(ps fnParams, node Node, sym *SymTable) []Node {
 	sub := sym.NewSymTable()
-	man, opt := c.extractParams(params)
 	// Add the mandatory arguments to the local symbol table.
-	sub.Add(man)
+	sub.Add(ps.man)
+	// Default values see the preceding parameters.
+	res := []Node{}
+	for _, def := range ps.defs {
+		res = mergeSymbols(res, c.listClosureParams(def.val, sub))
+		sub.AddVar(def.name)
+	}
 	// Check for an optional argument.
-	if opt != "" {
+	if ps.rest != "" {
 		// Add the optional argument to the local symbol table.
-		sub.AddVar(opt)
+		sub.AddVar(ps.rest)
 	}
-	return c.listClosureParams(node, sub)
+	return mergeSymbols(res, c.listClosureParams(node, sub))
 }
 
 func (c *Compiler) listClosureParams(node Node, sym *SymTable) []Node {
@@ -636,8 +1540,10 @@ func (c *Compiler) listClosureParams(node Node, sym *SymTable) []Node {
 		if ok && idx < 0 {
 			return []Node{n}
 		}
-	case []Node:
-		return c.listClosureParamsList(n, sym)
+	case *VectorNode:
+		return c.listClosureParamsList(n.Items, sym)
+	case *MapNode:
+		return c.listClosureParamsList(n.Items, sym)
 	case *ListNode:
 		return c.listClosureParamsList(n.Items, sym)
 	}
@@ -656,6 +1562,15 @@ func (c *Compiler) listClosureParamsList(nodes []Node, sym *SymTable) []Node {
 	return res
 }
 
+func containsSlot(slots []int, idx int) bool {
+	for _, slot := range slots {
+		if slot == idx {
+			return true
+		}
+	}
+	return false
+}
+
 func notContainsSymbol(syms []Node, s *SymbolNode) bool {
 	for _, sym := range syms {
 		if symx, ok := sym.(*SymbolNode); ok {
@@ -667,29 +1582,41 @@ func notContainsSymbol(syms []Node, s *SymbolNode) bool {
 	return true
 }
 
-func (c *Compiler) extractParams(params []Node) ([]string, string) {
-	names := c.verifyParams(params)
-	pos := util.IndexOf(names, "&")
-	if pos == -1 {
-		return names, ""
-	}
-	if len(names) == pos+1 {
-		c.error("[fn] missing optional parameter")
-		return names, ""
-	}
-	if len(names) > pos+2 {
-		c.error("[fn] excess optional parameter")
-		return names, ""
-	}
-	return names[:pos], names[pos+1]
-}
-
-func (c *Compiler) verifyParams(params []Node) []string {
-	names := make([]string, 0)
+// extractParams splits the parameters into the mandatory parameters, the
+// parameters with default values and the optional rest parameter. Default
+// parameters follow the mandatory parameters.
+func (c *Compiler) extractParams(params []Node) fnParams {
+	ps := fnParams{man: []string{}, defs: []defParam{}}
 	for pos, param := range params {
-		names = append(names, c.verifyParam(param, pos))
+		switch x := param.(type) {
+		case *SymbolNode:
+			if x.Name == "&" {
+				if len(params) == pos+1 {
+					c.error("[fn] missing optional parameter")
+				} else if len(params) > pos+2 {
+					c.error("[fn] excess optional parameter")
+				} else {
+					ps.rest = c.verifyParam(params[pos+1], pos+1)
+				}
+				return ps
+			}
+			if len(ps.defs) > 0 {
+				c.errorOn(x, "[fn] parameter [%d] requires a default value", pos)
+				return ps
+			}
+			ps.man = append(ps.man, x.Name)
+		case *ListNode:
+			if x.Len() != 2 || !IsSymbol(x.Items[0]) {
+				c.errorOn(x, "[fn] parameter [%d] is not a (name value) pair", pos)
+				return ps
+			}
+			ps.defs = append(ps.defs, defParam{x.Items[0].(*SymbolNode).Name, x.Items[1]})
+		default:
+			c.verifyParam(param, pos)
+			return ps
+		}
 	}
-	return names
+	return ps
 }
 
 func (c *Compiler) verifyParam(param Node, pos int) string {
@@ -697,31 +1624,36 @@ func (c *Compiler) verifyParam(param Node, pos int) string {
 	case *SymbolNode:
 		return sym.Name
 	default:
-		c.error("[fn] parameter [%d] is not a symbol", pos)
+		c.errorOn(param, "[fn] parameter [%d] is not a symbol", pos)
 		return ""
 	}
 }
 
-// compileRec compiles a manual recursive call. In fact it will only set the
-// Recursive flag to true. Subsequent compilations of call and list call will
-// consider the set flag and compile a recursive call instead of a regular one.
+// compileRec compiles a call that is asserted to be in tail position. Every
+// call in tail position is compiled to a tail call anyway. It is an error to
+// use rec anywhere else.
 func (c *Compiler) compileRec(args []Node, sym *SymTable, ctx *Ctx) {
 	if len(args) != 1 {
 		c.error("[rec] expects exactly 1 argument")
 		return
 	}
 
-	if x, ok := args[0].(*ListNode); ok {
-		c.compileList(x, sym, ctx.NewRecCtx(true))
-	} else {
+	x, ok := args[0].(*ListNode)
+	if !ok {
 		c.error("[rec] argument must be a list call")
+		return
 	}
+	if !ctx.TailCall() {
+		c.error("[rec] call is not in tail position")
+		return
+	}
+	c.compileList(x, sym, ctx)
 }
 
 func (c *Compiler) compileCall(s *SymbolNode, args []Node, sym *SymTable, ctx *Ctx) {
 	c.instr(vm.OpEnd)
-	// Reset recursive invocation for all arguments.
-	c.compileNodesReverse(args, sym, ctx.NewRecCtx(false))
+	// The arguments are never in tail position.
+	c.compileNodesReverse(args, sym, ctx.NonTail())
 	// The calling function can be implemented either as a global definintion
 	// or passed as a local argument from a let binding.
 	c.compileSymbol(s, sym, ctx)
@@ -730,21 +1662,29 @@ func (c *Compiler) compileCall(s *SymbolNode, args []Node, sym *SymTable, ctx *C
 
 func (c *Compiler) compileListCall(lst *ListNode, args []Node, sym *SymTable, ctx *Ctx) {
 	c.instr(vm.OpEnd)
-	c.compileNodesReverse(args, sym, ctx.NewRecCtx(false))
-	c.compileList(lst, sym, ctx)
+	c.compileNodesReverse(args, sym, ctx.NonTail())
+	c.compileList(lst, sym, ctx.NonTail())
 	c.compileCallByCtx(ctx)
 }
 
-// compileCallByCtx compiles either a Call or a RecCall depending on the
-// Recursive flag in the context object.
+// compileCallByCtx compiles a TailCall if the call is in tail position and a
+// regular Call otherwise. A tail call reuses the frame of the calling function.
 func (c *Compiler) compileCallByCtx(ctx *Ctx) {
-	if ctx.Recurse {
-		c.instr(vm.OpRecCall)
+	if ctx.TailCall() {
+		c.instr(vm.OpTailCall)
 	} else {
 		c.instr(vm.OpCall)
 	}
 }
 
+// compileDo compiles a sequence of expressions. Only the last expression is in
+// tail position.
+func (c *Compiler) compileDo(nodes []Node, sym *SymTable, ctx *Ctx) {
+	for i, node := range nodes {
+		c.compile(node, sym, ctx.NewTailCtx(ctx.Tail && i == len(nodes)-1))
+	}
+}
+
 func (c *Compiler) compileNodes(nodes []Node, sym *SymTable, ctx *Ctx) {
 	for _, node := range nodes {
 		c.compile(node, sym, ctx)
@@ -316,6 +316,13 @@ func TestCompileSet2(t *testing.T) {
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("f"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 2),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -395,45 +402,336 @@ func TestCompileLet4(t *testing.T) {
 	)
 }
 
-// TODO: GetArg 0 Gibs noch nicht!
-
 func TestCompileLet5(t *testing.T) {
 	testc(t, `
     (let
       (b (fn [m] (if (= m 0) 1 (b (- m 1))) ))
       (b 1) )`,
+		asm.Instr(vm.OpNil),
+		asm.Instr(vm.OpPushArgs, 1),
 		asm.Labeled(vm.OpJump, "L0"),
 		// BEGIN FN
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 2),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpGetArg, 1),
 		asm.Instr(vm.OpConst, 0),
 		asm.Instr(vm.OpEQ),
-		asm.Labeled(vm.OpJumpIfNot, "L2"),
+		asm.Labeled(vm.OpJumpIfNot, "L3"),
 		asm.Instr(vm.OpConst, 1),
-		asm.Labeled(vm.OpJump, "L3"),
-		asm.Label("L2"),
+		asm.Labeled(vm.OpJump, "L4"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpGetArg, 1),
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpSub),
 		asm.Instr(vm.OpGetArg, 0),
-		asm.Instr(vm.OpCall),
-		asm.Label("L3"),
+		asm.Instr(vm.OpTailCall),
+		asm.Label("L4"),
 		asm.Instr(vm.OpReturn),
 		// END FN
 		asm.Label("L0"),
-		// TODO: An dieser Stelle ist noch nichts in FRAME[0].
-		//       Ref erwartet hier als argument sich selbst.
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Ref(1, "L1"),
+		asm.Instr(vm.OpSetArg, 0),
+		// The closure captures itself.
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpPatchRef, 0),
 		// BEGIN LET
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpCall),
+		asm.Instr(vm.OpDropArgs, 1),
+	)
+}
+
+func TestCompileLetShadowing(t *testing.T) {
+	testc(t, "(fn [a] (let (a (+ a 1) b a) (+ a b)))",
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpAdd),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 2),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpAdd),
+		asm.Instr(vm.OpDropArgs, 2),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+	)
+}
+
+func TestCompileLetrec(t *testing.T) {
+	testc(t, "(letrec (f (fn [] (g)) g (fn [] (f))) (f))",
+		asm.Instr(vm.OpNil),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpNil),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpTailCall),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Ref(1, "L1"),
+		asm.Instr(vm.OpSetArg, 0),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L5"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpTailCall),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L3"),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Ref(1, "L4"),
+		asm.Instr(vm.OpSetArg, 1),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpPatchRef, 0),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpPatchRef, 0),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpCall),
+		asm.Instr(vm.OpDropArgs, 2),
+	)
+}
+
+func TestCompileSetBang(t *testing.T) {
+	testc(t, "(let (n 0) (fn [] (set! n 1)))",
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpMakeCell),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpSetCell),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Ref(1, "L1"),
+		asm.Instr(vm.OpDropArgs, 1),
+	)
+}
+
+func TestCompileSetBangLocal(t *testing.T) {
+	testc(t, "(let (n 0) (do (set! n 1) n))",
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpSetArg, 0),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpDropArgs, 1),
+	)
+}
+
+func TestCompileFnClauses(t *testing.T) {
+	testc(t, "(fn ([a] a) ([a b & c] b))",
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpLT),
+		asm.Labeled(vm.OpJumpIfNot, "L3"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L3"),
+		asm.Instr(vm.OpPushArgs, 2),
+		asm.Instr(vm.OpList),
 		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+	)
+}
+
+func TestCompileFnDefaults(t *testing.T) {
+	testc(t, "(fn [a (b a)] b)",
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpLT),
+		asm.Labeled(vm.OpJumpIf, "L3"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpLE),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Label("L3"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L4"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Labeled(vm.OpJump, "L5"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Label("L5"),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+	)
+}
+
+func TestCompileFnBodyDo(t *testing.T) {
+	testc(t, "(fn [] 1 2)",
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+	)
+}
+
+func TestCompileLoop(t *testing.T) {
+	testc(t, "(loop (i 0) (if (< i 9) (recur (+ i 1)) i))",
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Label("L0"),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpConst, 9),
+		asm.Instr(vm.OpLT),
+		asm.Labeled(vm.OpJumpIfNot, "L1"),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpAdd),
+		asm.Instr(vm.OpSetArg, 0),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Labeled(vm.OpJump, "L2"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpDropArgs, 1),
+	)
+}
+
+func TestCompileLoopRecurDropsLet(t *testing.T) {
+	testc(t, "(loop (a 1 b 2) (let (c a) (recur b c)))",
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Label("L0"),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpGetArg, 1),
+		asm.Instr(vm.OpGetArg, 2),
+		asm.Instr(vm.OpSetArg, 1),
+		asm.Instr(vm.OpSetArg, 0),
+		asm.Instr(vm.OpDropArgs, 1),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Instr(vm.OpDropArgs, 1),
+		asm.Instr(vm.OpDropArgs, 2),
+	)
+}
+
+func TestCompileSwap(t *testing.T) {
+	testc(t, "(swap! (atom 1) + 2)",
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpAtom),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpDeref),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpPushArgs, 0),
+		asm.Instr(vm.OpList),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpDissolve),
+		asm.Instr(vm.OpAdd),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
 		asm.Instr(vm.OpCall),
+		asm.Instr(vm.OpReset),
 		asm.Instr(vm.OpDropArgs, 1),
 	)
 }
@@ -707,6 +1005,81 @@ func TestCompileVector1(t *testing.T) {
 	)
 }
 
+func TestCompileMap(t *testing.T) {
+	testc(t, `{"a" 1 [2] 3.5}`,
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConstF, math.Float64bits(3.5)),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpList),
+		asm.Instr(vm.OpConst, 1),
+		asm.Str("a"),
+		asm.Instr(vm.OpMap),
+	)
+}
+
+func TestCompileMapGet(t *testing.T) {
+	testc(t, `(get {} "a" 0)`,
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 0),
+		asm.Str("a"),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpMap),
+		asm.Instr(vm.OpGet),
+	)
+}
+
+func TestCompileTry(t *testing.T) {
+	testc(t, "(try (throw 1) (catch e e))",
+		asm.Labeled(vm.OpTry, "L0"),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpThrow),
+		asm.Instr(vm.OpEndTry),
+		asm.Labeled(vm.OpJump, "L1"),
+		asm.Label("L0"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpDropArgs, 1),
+		asm.Label("L1"),
+	)
+}
+
+func TestCompileQuasiquote(t *testing.T) {
+	testc(t, "`(a ~1 ~@[2] [b])",
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpEnd),
+		asm.Symbol("b"),
+		asm.Instr(vm.OpList),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpList),
+		asm.Instr(vm.OpDissolve),
+		asm.Instr(vm.OpConst, 1),
+		asm.Symbol("a"),
+		asm.Instr(vm.OpMakeList),
+	)
+}
+
+func TestCompileNil(t *testing.T) {
+	testc(t, "nil",
+		asm.Instr(vm.OpNil),
+	)
+}
+
+func TestCompileTypePredicate(t *testing.T) {
+	testc(t, "(map? {})",
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpMap),
+		asm.Instr(vm.OpIs, vm.TypeMap),
+	)
+}
+
+func TestCompileKeyword(t *testing.T) {
+	testc(t, ":foo",
+		asm.Keyword("foo"),
+	)
+}
+
 func TestCompileVector2(t *testing.T) {
 	testc(t, "(.+ 1 (.+ 2 (.+ 3 [])))",
 		asm.Instr(vm.OpEmptyVector),
@@ -791,6 +1164,13 @@ func TestCompileAnonymousFun0(t *testing.T) {
 	testc(t, `(fn [] 1)`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpReturn),
@@ -803,6 +1183,13 @@ func TestCompileAnonymousFun1(t *testing.T) {
 	testc(t, `(fn [x] (+ x 1))`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -819,9 +1206,23 @@ func TestCompileAnonymousFun11(t *testing.T) {
 	testc(t, `(fn [] (fn [x] (+ x 1)))`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPop),
-		asm.Labeled(vm.OpJump, "L2"),
-		asm.Label("L3"),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L5"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -829,8 +1230,8 @@ func TestCompileAnonymousFun11(t *testing.T) {
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpAdd),
 		asm.Instr(vm.OpReturn),
-		asm.Label("L2"),
-		asm.Ref(0, "L3"),
+		asm.Label("L3"),
+		asm.Ref(0, "L4"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
@@ -843,6 +1244,13 @@ func TestCompileAnonymousFun2(t *testing.T) {
 		asm.Instr(vm.OpConst, 1),
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -864,6 +1272,13 @@ func TestCompileAnonymousFun3(t *testing.T) {
 		asm.Instr(vm.OpConst, 1),
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -885,9 +1300,23 @@ func TestCompileAnonymousFun4(t *testing.T) {
 		asm.Instr(vm.OpEnd),
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPop),
-		asm.Labeled(vm.OpJump, "L2"),
-		asm.Label("L3"),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L5"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -895,8 +1324,8 @@ func TestCompileAnonymousFun4(t *testing.T) {
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpAdd),
 		asm.Instr(vm.OpReturn),
-		asm.Label("L2"),
-		asm.Ref(0, "L3"),
+		asm.Label("L3"),
+		asm.Ref(0, "L4"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
@@ -911,13 +1340,27 @@ func TestCompileAnonymousNestedFun(t *testing.T) {
 		asm.Instr(vm.OpConst, 6),
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpConst, 3),
-		asm.Labeled(vm.OpJump, "L2"),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
 		// (fn [m] (/ n m))
-		asm.Label("L3"),
+		asm.Label("L5"),
 		asm.Instr(vm.OpPushArgs, 2),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpGetArg, 0),
@@ -925,10 +1368,10 @@ func TestCompileAnonymousNestedFun(t *testing.T) {
 		asm.Instr(vm.OpDiv),
 		asm.Instr(vm.OpReturn),
 		// end
-		asm.Label("L2"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpGetArg, 0),
-		asm.Ref(1, "L3"),
-		asm.Instr(vm.OpCall),
+		asm.Ref(1, "L4"),
+		asm.Instr(vm.OpTailCall),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
@@ -944,6 +1387,13 @@ func TestCompileLeafFunDef(t *testing.T) {
     )`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("inc"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -993,6 +1443,13 @@ func TestCompileVariadicFun2(t *testing.T) {
 		asm.Instr(vm.OpConst, 1),
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpLT),
+		asm.Labeled(vm.OpJumpIfNot, "L2"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpList),
 		asm.Instr(vm.OpPushArgs, 1),
@@ -1023,15 +1480,22 @@ func TestCompileFac(t *testing.T) {
     )`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("fac"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpConst, 0),
 		asm.Instr(vm.OpEQ),
-		asm.Labeled(vm.OpJumpIfNot, "L2"),
+		asm.Labeled(vm.OpJumpIfNot, "L3"),
 		asm.Instr(vm.OpConst, 1),
-		asm.Labeled(vm.OpJump, "L3"),
-		asm.Label("L2"),
+		asm.Labeled(vm.OpJump, "L4"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpGetArg, 0),
@@ -1041,7 +1505,7 @@ func TestCompileFac(t *testing.T) {
 		asm.Instr(vm.OpCall),
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpMul),
-		asm.Label("L3"),
+		asm.Label("L4"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
@@ -1071,15 +1535,22 @@ func TestCompileTailFac(t *testing.T) {
     )`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("_fac"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 2),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpConst, 0),
 		asm.Instr(vm.OpEQ),
-		asm.Labeled(vm.OpJumpIfNot, "L2"),
+		asm.Labeled(vm.OpJumpIfNot, "L3"),
 		asm.Instr(vm.OpGetArg, 1),
-		asm.Labeled(vm.OpJump, "L3"),
-		asm.Label("L2"),
+		asm.Labeled(vm.OpJump, "L4"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpGetArg, 1),
@@ -1089,24 +1560,31 @@ func TestCompileTailFac(t *testing.T) {
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpSub),
 		asm.Instr(vm.OpGetGlobal, 0),
-		asm.Instr(vm.OpRecCall),
-		asm.Label("L3"),
+		asm.Instr(vm.OpTailCall),
+		asm.Label("L4"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
 		asm.Instr(vm.OpSetGlobal, 0),
-		asm.Labeled(vm.OpJump, "L4"),
-		asm.Label("L5"),
+		asm.Labeled(vm.OpJump, "L5"),
+		asm.Label("L6"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L7"),
+		asm.Str("fac"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L7"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpGetGlobal, 0),
-		asm.Instr(vm.OpCall),
+		asm.Instr(vm.OpTailCall),
 		asm.Instr(vm.OpReturn),
-		asm.Label("L4"),
-		asm.Ref(0, "L5"),
+		asm.Label("L5"),
+		asm.Ref(0, "L6"),
 		asm.Instr(vm.OpSetGlobal, 1),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpConst, 5),
@@ -1115,6 +1593,63 @@ func TestCompileTailFac(t *testing.T) {
 	)
 }
 
+func TestCompileTailCallInOr(t *testing.T) {
+	testc(t, `(def g (fn [x] (or x (g x))))`,
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("g"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Labeled(vm.OpJumpIf, "L3"),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpGetGlobal, 0),
+		asm.Instr(vm.OpTailCall),
+		asm.Labeled(vm.OpJump, "L4"),
+		asm.Label("L3"),
+		asm.Instr(vm.OpTrue),
+		asm.Label("L4"),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+		asm.Instr(vm.OpSetGlobal, 0),
+	)
+}
+
+func TestCompileNoTailCallInArgument(t *testing.T) {
+	testc(t, `(def g (fn [x] (+ 1 (g x))))`,
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("g"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPushArgs, 1),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpGetArg, 0),
+		asm.Instr(vm.OpGetGlobal, 0),
+		asm.Instr(vm.OpCall),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpAdd),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+		asm.Instr(vm.OpSetGlobal, 0),
+	)
+}
+
 func TestCompileClosure1(t *testing.T) {
 	testc(t, `
     (do
@@ -1123,17 +1658,31 @@ func TestCompileClosure1(t *testing.T) {
     )`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("ggg"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
-		asm.Labeled(vm.OpJump, "L2"),
-		asm.Label("L3"),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L5"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpReturn),
-		asm.Label("L2"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpGetArg, 0),
-		asm.Ref(1, "L3"),
+		asm.Ref(1, "L4"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
@@ -1158,14 +1707,28 @@ func TestCompileClosure2(t *testing.T) {
     )`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("m2"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpConst, 1),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpEnd),
 		asm.Instr(vm.OpGetArg, 0),
-		asm.Labeled(vm.OpJump, "L2"),
-		asm.Label("L3"),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L5"),
 		asm.Instr(vm.OpPushArgs, 2),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpEnd),
@@ -1173,10 +1736,10 @@ func TestCompileClosure2(t *testing.T) {
 		asm.Instr(vm.OpGetArg, 1),
 		asm.Instr(vm.OpAdd),
 		asm.Instr(vm.OpReturn),
-		asm.Label("L2"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpGetArg, 1),
-		asm.Ref(1, "L3"),
-		asm.Instr(vm.OpCall),
+		asm.Ref(1, "L4"),
+		asm.Instr(vm.OpTailCall),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		asm.Ref(0, "L1"),
@@ -1265,19 +1828,33 @@ func TestCompileClosure(t *testing.T) {
     )`,
 		asm.Labeled(vm.OpJump, "L0"),
 		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("divN"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
 		asm.Instr(vm.OpPushArgs, 1),
 		asm.Instr(vm.OpPop),
-		asm.Labeled(vm.OpJump, "L2"),
-		asm.Label("L3"),
+		asm.Labeled(vm.OpJump, "L3"),
+		asm.Label("L4"),
+		asm.Instr(vm.OpArgc, 1),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L5"),
+		asm.Str("fn"),
+		asm.Instr(vm.OpArityError, 1),
+		asm.Label("L5"),
 		asm.Instr(vm.OpPushArgs, 2),
 		asm.Instr(vm.OpPop),
 		asm.Instr(vm.OpGetArg, 1),
 		asm.Instr(vm.OpGetArg, 0),
 		asm.Instr(vm.OpDiv),
 		asm.Instr(vm.OpReturn),
-		asm.Label("L2"),
+		asm.Label("L3"),
 		asm.Instr(vm.OpGetArg, 0),
-		asm.Ref(1, "L3"),
+		asm.Ref(1, "L4"),
 		asm.Instr(vm.OpReturn),
 		asm.Label("L0"),
 		// def divN
@@ -1328,6 +1905,92 @@ func TestCompileHalt(t *testing.T) {
 	)
 }
 
+func TestCompileErrorUnknownSymbol(t *testing.T) {
+	testce(t, "(do\n  (+ 1 foo))", "test.splis:2:8: unknown symbol [foo]")
+}
+
+func TestCompileErrorRecNotInTailPosition(t *testing.T) {
+	testce(t, "(def f (fn [x] (+ 1 (rec (f x)))))", "test.splis:1:21: [rec] call is not in tail position")
+}
+
+func TestCompileErrorRecur(t *testing.T) {
+	testce(t, "(recur 1)", "test.splis:1:1: [recur] is not in a loop")
+	testce(t, "(loop (i 0) (+ 1 (recur i)))", "test.splis:1:18: [recur] is not in tail position")
+	testce(t, "(loop (i 0) (recur))", "test.splis:1:13: [recur] requires [1] arguments but got [0]")
+	testce(t, "(loop (i 0) (fn [] (recur 1)))", "test.splis:1:20: [recur] is not in a loop")
+	testce(t, "(loop (i 0 2 1) i)", "test.splis:1:12: [loop] cannot bind to [2]")
+}
+
+func TestCompileErrorFn(t *testing.T) {
+	testce(t, "(fn)", "test.splis:1:1: [fn] expects a parameter list and a body or clauses ([params] body)")
+	testce(t, "(fn ([a] a) 1)", "test.splis:1:1: [fn] expects a parameter list and a body or clauses ([params] body)")
+	testce(t, "(fn ([a] a) ([b] b))", "test.splis:1:1: [fn] clauses [1] and [2] accept [1] arguments")
+	testce(t, "(fn ([a] a) ([& b] b))", "test.splis:1:1: [fn] clauses [1] and [2] accept [1] arguments")
+	testce(t, "(fn [(a 1) b] a)", "test.splis:1:12: [fn] parameter [1] requires a default value")
+	testce(t, "(fn [(a)] 1)", "test.splis:1:6: [fn] parameter [0] is not a (name value) pair")
+}
+
+func TestCompileErrorKeywordCall(t *testing.T) {
+	testce(t, "(:foo 1)", "test.splis:1:2: keyword [:foo] is not a function")
+}
+
+func TestCompileLegacyKeywordCall(t *testing.T) {
+	testc(t, "(:+ 1 2)",
+		asm.Instr(vm.OpEnd),
+		asm.Instr(vm.OpConst, 2),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpAdd),
+	)
+}
+
+func TestCompileErrorTry(t *testing.T) {
+	testce(t, "(try 1 2)", "test.splis:1:1: [try] requires second argument to be a catch clause")
+	testce(t, "(try 1 (catch 2 3))", "test.splis:1:15: [catch] cannot bind to [2]")
+}
+
+func TestCompileErrorLiteral(t *testing.T) {
+	testce(t, "(let (1 2) x)", "test.splis:1:7: [let] cannot bind to [1]")
+	testce(t, "(do\n  (def \"x\" 1))", "test.splis:2:8: [def] requires first argument to be a symbol")
+	testce(t, "(fn [a\n     :b] a)", "test.splis:2:6: [fn] parameter [1] is not a symbol")
+	testce(t, "(let [a 1] a)", "test.splis:1:6: [let] requires first argument to be a list of bindings")
+}
+
+func TestCompileErrorSpecialForm(t *testing.T) {
+	testce(t, "(do 1\n   (set x))", "test.splis:2:4: [set] requires exactly two arguments")
+}
+
+func TestCompileDebugInfo(t *testing.T) {
+	r := cmp.NewReader()
+	p := cmp.NewParser()
+	c := cmp.NewCompiler()
+	c.EnableDebugInfo()
+
+	r.LoadFile("t", "(def f\n  (fn [] 1))")
+	s := c.Compile(p.Parse(r))
+
+	compareAssembly(t, s, []asm.AsmCmd{
+		asm.SrcPos(vm.SrcPos{File: "t", Line: 1, Col: 1}),
+		asm.SrcPos(vm.SrcPos{File: "t", Line: 2, Col: 3}),
+		asm.Fn("f", "L1", "L0", vm.SrcPos{File: "t", Line: 2, Col: 3}),
+		asm.Labeled(vm.OpJump, "L0"),
+		asm.Label("L1"),
+		asm.Instr(vm.OpArgc, 0),
+		asm.Instr(vm.OpConst, 0),
+		asm.Instr(vm.OpEQ),
+		asm.Labeled(vm.OpJumpIf, "L2"),
+		asm.Str("f"),
+		asm.Instr(vm.OpArityError, 0),
+		asm.Label("L2"),
+		asm.Instr(vm.OpPop),
+		asm.Instr(vm.OpConst, 1),
+		asm.Instr(vm.OpReturn),
+		asm.Label("L0"),
+		asm.Ref(0, "L1"),
+		asm.SrcPos(vm.SrcPos{File: "t", Line: 1, Col: 1}),
+		asm.Instr(vm.OpSetGlobal, 0),
+	})
+}
+
 func testc(t *testing.T, i string, e ...asm.AsmCmd) {
 	t.Helper()
 	r := cmp.NewReader()
@@ -1400,3 +2063,23 @@ func compareAssembly(t *testing.T, a []asm.AsmCmd, e []asm.AsmCmd) {
 		t.Errorf("\n%s\n", buf.String())
 	}
 }
+
+// testce compiles the input and expects exactly one compiler error e.
+func testce(t *testing.T, i string, e string) {
+	t.Helper()
+	r := cmp.NewReader()
+	p := cmp.NewParser()
+	c := cmp.NewCompiler()
+
+	r.LoadFile("test.splis", i)
+	n := p.Parse(r)
+	c.Compile(n)
+
+	errs := c.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("Expecting [1] error but got %v", errs)
+	}
+	if errs[0] != e {
+		t.Errorf("Expecting [%s] but got [%s]", e, errs[0])
+	}
+}
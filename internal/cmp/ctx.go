@@ -1,27 +1,57 @@
 package cmp
 
+// Ctx describes the position of the expression that is being compiled.
 type Ctx struct {
-	Recurse bool
-	// IsVal   bool
-	// Last    bool
+	// Tail is set if the value of the expression is returned from the enclosing
+	// function or loop right away. Calls in tail position reuse the frame of the
+	// calling function unless the loop is not in tail position itself.
+	Tail bool
+	// Loop is the innermost loop of the enclosing function if there is any.
+	Loop *Loop
+}
+
+// Loop describes a loop that recur jumps back to.
+type Loop struct {
+	// Label is the head of the loop.
+	Label string
+	// Names are the names of the loop bindings.
+	Names []string
+	// Scope contains the loop bindings.
+	Scope *SymTable
+	// Tail is set if the loop is in tail position of the enclosing function.
+	Tail bool
 }
 
 func NewCtx() *Ctx {
 	return &Ctx{}
 }
 
-func (c *Ctx) NewCtx() *Ctx {
+// NewTailCtx returns a context for a subexpression that is in tail position
+// if tail is set.
+func (c *Ctx) NewTailCtx(tail bool) *Ctx {
 	return &Ctx{
-		Recurse: c.Recurse,
-		// IsVal:   c.IsVal,
-		// Last:    c.Last,
+		Tail: tail,
+		Loop: c.Loop,
 	}
 }
 
-func (c *Ctx) NewRecCtx(recurse bool) *Ctx {
+// NonTail returns a context for a subexpression whose value is processed
+// further by the enclosing expression.
+func (c *Ctx) NonTail() *Ctx {
+	return c.NewTailCtx(false)
+}
+
+// NewLoopCtx returns the context for the body of the loop. The body is in
+// tail position.
+func (c *Ctx) NewLoopCtx(loop *Loop) *Ctx {
 	return &Ctx{
-		Recurse: recurse,
-		// IsVal:   c.IsVal,
-		// Last:    c.Last,
+		Tail: true,
+		Loop: loop,
 	}
 }
+
+// TailCall returns true if a call in this position may reuse the frame of
+// the calling function.
+func (c *Ctx) TailCall() bool {
+	return c.Tail && (c.Loop == nil || c.Loop.Tail)
+}
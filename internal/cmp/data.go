@@ -20,16 +20,17 @@ func (d specDefs) add(name string, fn specFun) {
 }
 
 type primDef struct {
-	name  string
-	op    vm.Op
-	nargs int
-	rev   bool
+	name     string
+	op       vm.Op
+	nargs    int
+	rev      bool
+	operands []uint64
 }
 
 type primDefs map[string]primDef
 
-func (d primDefs) add(name string, op vm.Op, nargs int, rev bool) {
-	d[name] = primDef{name: name, op: op, nargs: nargs, rev: rev}
+func (d primDefs) add(name string, op vm.Op, nargs int, rev bool, operands ...uint64) {
+	d[name] = primDef{name: name, op: op, nargs: nargs, rev: rev, operands: operands}
 }
 
 type varPrimDef struct {
@@ -79,6 +80,15 @@ func (d *defMap) getOrAdd(name string) uint64 {
 	return d.add(name)
 }
 
+// truncate removes the names with IDs from index onwards.
+func (d *defMap) truncate(index uint64) {
+	for id := index; id < d.index; id++ {
+		delete(d.ids, d.names[id])
+		delete(d.names, id)
+	}
+	d.index = index
+}
+
 func (d *defMap) nextID() uint64 {
 	next := d.index
 	d.index++
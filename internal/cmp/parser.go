@@ -8,6 +8,7 @@ import (
 type Parser struct {
 	rd  *Reader
 	tok string
+	pos Pos
 	err []*ErrorNode
 }
 
@@ -22,24 +23,38 @@ func (p *Parser) Parse(r *Reader) Node {
 	return p.parse()
 }
 
+// ParseAll parses all forms of the input in sequence.
+func (p *Parser) ParseAll(r *Reader) []Node {
+	p.rd = r
+	p.err = []*ErrorNode{}
+	p.next()
+	ns := []Node{}
+	for p.tok != "" {
+		ns = append(ns, p.parse())
+	}
+	return ns
+}
+
 func (p *Parser) Errors() []*ErrorNode {
 	return p.err
 }
 
 func (p *Parser) next() {
 	p.tok = p.rd.Next()
+	p.pos = p.rd.Pos()
 }
 
 func (p *Parser) consume(exp string) {
 	if p.tok == exp {
 		p.next()
 	} else {
-		p.error("Unexpected [%s]. Expecting [%s].\n", p.tok, exp)
+		p.error("Unexpected [%s]. Expecting [%s].", p.tok, exp)
 	}
 }
 
 func (p *Parser) error(format string, args ...interface{}) Node {
 	e := NewError(format, args...)
+	e.Pos = p.pos
 	p.err = append(p.err, e)
 	p.next() // Ignore the malign token and move on.
 	return e
@@ -48,48 +63,65 @@ func (p *Parser) error(format string, args ...interface{}) Node {
 func (p *Parser) parse() Node {
 	switch {
 	case p.tok == ")":
-		return p.error("Unexpected [)].\n")
+		return p.error("Unexpected [)].")
 	case p.tok == "(":
 		return p.parseList()
 	case p.tok == "]":
-		return p.error("Unexpected []].\n")
+		return p.error("Unexpected []].")
 	case p.tok == "[":
-		return p.parseArgs("[", "]")
+		return p.parseVector()
 	case p.tok == "}":
-		return p.error("Unexpected [}].\n")
+		return p.error("Unexpected [}].")
 	case p.tok == "{":
 		return p.parseHashMap()
 	case p.tok == "'":
-		p.consume("'")
-		return Quote(p.parse())
+		return p.parseReaderMacro("'", Quote)
+	case p.tok == "`":
+		return p.parseReaderMacro("`", Quasiquote)
 	case p.tok == "~":
-		p.consume("~")
-		return Unquote(p.parse())
+		return p.parseReaderMacro("~", Unquote)
 	case p.tok == "@":
-		p.consume("@")
-		return Dissolve(p.parse())
+		return p.parseReaderMacro("@", Dissolve)
 	default:
 		return p.parseAtom()
 	}
 }
 
+// parseReaderMacro parses a prefix character followed by a form and wraps
+// the form in the special form created by fn.
+func (p *Parser) parseReaderMacro(prefix string, fn func(Node) *ListNode) Node {
+	pos := p.pos
+	p.consume(prefix)
+	n := fn(p.parse())
+	n.Pos = pos
+	return n
+}
+
 func (p *Parser) parseList() Node {
-	return NewList(p.parseArgs("(", ")"))
+	pos := p.pos
+	return NewListAt(p.parseArgs("(", ")"), pos)
+}
+
+func (p *Parser) parseVector() Node {
+	pos := p.pos
+	return NewVectorAt(p.parseArgs("[", "]"), pos)
 }
 
 func (p *Parser) parseHashMap() Node {
-	n := Map{}
+	pos := p.pos
+	items := []Node{}
 	p.consume("{")
-	// TODO: parse first and check if the count is even.
 	for p.tok != "}" && p.tok != "" {
-		key := p.parse()
-		if k, ok := key.(string); ok {
-			v := p.parse()
-			n[k] = v
+		items = append(items, p.parse())
+		if p.tok == "}" {
+			e := p.error("Missing value for key [%s] in hash map.", PrintAst(items[len(items)-1]))
+			p.consume("}")
+			return e
 		}
+		items = append(items, p.parse())
 	}
 	p.consume("}")
-	return n
+	return NewMapAt(items, pos)
 }
 
 // TODO: link to parent node to provide more context for errorNodes.
@@ -104,7 +136,10 @@ func (p *Parser) parseArgs(start string, end string) []Node {
 	return args
 }
 
+// parseAtom parses a symbol or a literal. Literals are wrapped in positioned
+// literal nodes.
 func (p *Parser) parseAtom() Node {
+	pos := p.pos
 	var n Node
 	switch {
 	case strings.HasPrefix(p.tok, `"`):
@@ -116,12 +151,16 @@ func (p *Parser) parseAtom() Node {
 	case p.tok == "false":
 		n = false
 	case p.tok == "nil":
-		n = nil
+		n = Nil{}
 	default:
 		n = p.parseSymbol()
 	}
 	p.next()
-	return n
+	switch n.(type) {
+	case *SymbolNode, *ErrorNode:
+		return n
+	}
+	return NewLitAt(n, pos)
 }
 
 func (p *Parser) parseString() Node {
@@ -129,7 +168,7 @@ func (p *Parser) parseString() Node {
 		// TODO: Create a constant for the empty string.
 		return normalizeString(p.tok)
 	}
-	return p.error("Missing [\"].\n")
+	return p.error("Missing [\"].")
 }
 
 func normalizeString(val string) string {
@@ -146,7 +185,7 @@ func (p *Parser) parseNumber() Node {
 	if v, err := strconv.ParseFloat(p.tok, 64); err == nil {
 		return v
 	}
-	return p.error("[%s] is not a number.\n", p.tok)
+	return p.error("[%s] is not a number.", p.tok)
 }
 
 func isNumber(tok string) bool {
@@ -160,8 +199,11 @@ func isDigit(tok byte) bool {
 	return '0' <= tok && tok <= '9'
 }
 
-// TODO: Keywords :<x> <-> ʞ<x>
-
+// parseSymbol parses a symbol or a keyword. Keywords start with a colon.
+// The symbols : and :: are the cons primitives and not keywords.
 func (p *Parser) parseSymbol() Node {
-	return NewSymbol(p.tok)
+	if len(p.tok) > 1 && strings.HasPrefix(p.tok, ":") && p.tok[1] != ':' {
+		return Keyword(p.tok[1:])
+	}
+	return NewSymbolAt(p.tok, p.pos)
 }
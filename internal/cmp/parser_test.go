@@ -82,8 +82,12 @@ func TestParseUnexpectedSeqClosingTag(t *testing.T) {
 func TestParseHashMaps(t *testing.T) {
 	testpw(t, " {   } ", "{}")
 	testpw(t, ` { "a" 1 } `, `{"a" 1}`)
-	// TODO: Order is not guaranteed.
-	// testpw(t, ` { "a" 1"b"  2 } `, `{"a" 1 "b" 2}`)
+	testpw(t, ` { "a" 1"b"  2 } `, `{"a" 1 "b" 2}`)
+	testpw(t, ` {1 "x" [2] y} `, `{1 "x" [2] y}`)
+}
+
+func TestParseHashMapMissingValue(t *testing.T) {
+	testpw(t, ` {"a" 1 "b"} `, `  [ERROR]  `)
 }
 
 func TestParseIncompleteHashMaps(t *testing.T) {
@@ -92,6 +96,13 @@ func TestParseIncompleteHashMaps(t *testing.T) {
 	testpw(t, ` {"a" 1 `, `{"a" 1}`)
 }
 
+func TestParseKeywords(t *testing.T) {
+	testpw(t, " :foo ", ":foo")
+	testpw(t, " {:a 1 :b-c? 2} ", "{:a 1 :b-c? 2}")
+	testpw(t, " (: 1 []) ", "(: 1 [])")
+	testpw(t, " (:: 1 []) ", "(:: 1 [])")
+}
+
 func TestParseQuote(t *testing.T) {
 	testpw(t, " '42 ", "(quote 42)")
 	testpw(t, ` '"x" `, `(quote "x")`)
@@ -101,6 +112,11 @@ func TestParseQuote(t *testing.T) {
 	testpw(t, ` '{ "a" 1 } `, `(quote {"a" 1})`)
 }
 
+func TestParseQuasiquote(t *testing.T) {
+	testpw(t, " `a ", "(quasiquote a)")
+	testpw(t, " `(+ ~a ~@b) ", "(quasiquote (+ (unquote a) (unquote (dissolve b))))")
+}
+
 func TestParseQuoteUnquote(t *testing.T) {
 	testpw(t, " '~42 ", "(quote (unquote 42))")
 	testpw(t, " '(+ ~a ~b) ", "(quote (+ (unquote a) (unquote b)))")
@@ -111,6 +127,61 @@ func TestParseQuoteSpliceUnquote(t *testing.T) {
 	testpw(t, " '(+ ~@(a b) c) ", "(quote (+ (unquote (dissolve (a b))) c))")
 }
 
+func TestParseSymbolPosition(t *testing.T) {
+	n := testParse("\n  (foo\n   bar)")
+	l := n.(*cmp.ListNode)
+	testPos(t, "2:3", l.Pos)
+	testPos(t, "2:4", cmp.PosOf(l.Items[0]))
+	testPos(t, "3:4", cmp.PosOf(l.Items[1]))
+}
+
+func TestParseLiteralPosition(t *testing.T) {
+	n := testParse("(f 1 2.5\n \"s\" :k nil [true\n  {}])")
+	l := n.(*cmp.ListNode)
+	testPos(t, "1:4", cmp.PosOf(l.Items[1]))
+	testPos(t, "1:6", cmp.PosOf(l.Items[2]))
+	testPos(t, "2:2", cmp.PosOf(l.Items[3]))
+	testPos(t, "2:6", cmp.PosOf(l.Items[4]))
+	testPos(t, "2:9", cmp.PosOf(l.Items[5]))
+	v := l.Items[6].(*cmp.VectorNode)
+	testPos(t, "2:13", v.Pos)
+	testPos(t, "2:14", cmp.PosOf(v.Items[0]))
+	testPos(t, "3:3", cmp.PosOf(v.Items[1]))
+}
+
+func TestParseQuotePosition(t *testing.T) {
+	n := testParse(" '(foo)")
+	testPos(t, "1:2", cmp.PosOf(n))
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	r := cmp.NewReader()
+	r.LoadFile("test.splis", "(foo\n  bar]")
+	p := cmp.NewParser()
+	p.Parse(r)
+	if len(p.Errors()) == 0 {
+		t.Fatalf("Expecting errors but got none")
+	}
+	e := "test.splis:2:6: Unexpected []]."
+	if a := p.Errors()[0].Error(); a != e {
+		t.Errorf("Expecting [%s] but got [%s]", e, a)
+	}
+}
+
+func testParse(i string) cmp.Node {
+	r := cmp.NewReader()
+	r.Load(i)
+	p := cmp.NewParser()
+	return p.Parse(r)
+}
+
+func testPos(t *testing.T, e string, pos cmp.Pos) {
+	t.Helper()
+	if a := pos.String(); a != e {
+		t.Errorf("Expecting position [%s] but got [%s]", e, a)
+	}
+}
+
 func testpw(t *testing.T, i string, e string) {
 	r := cmp.NewReader()
 	r.Load(i)
@@ -127,7 +198,7 @@ func testpi(t *testing.T, i string, e int64) {
 	r.Load(i)
 	p := cmp.NewParser()
 	n := p.Parse(r)
-	if a, ok := n.(int64); !ok || a != e {
+	if a, ok := cmp.Lit(n).(int64); !ok || a != e {
 		t.Errorf("Expecting [%d] but got [%d]", e, a)
 	}
 }
@@ -137,7 +208,7 @@ func testpf(t *testing.T, i string, e float64) {
 	r.Load(i)
 	p := cmp.NewParser()
 	n := p.Parse(r)
-	if a, ok := n.(float64); !ok || a != e {
+	if a, ok := cmp.Lit(n).(float64); !ok || a != e {
 		t.Errorf("Expecting [%v] but got [%v]", e, a)
 	}
 }
@@ -0,0 +1,73 @@
+package cmp
+
+import (
+	"fmt"
+
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// Pos describes a location in a source file. Lines and columns start at 1. A
+// position with line 0 is invalid and denotes an unknown location.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+// IsValid reports whether the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position in the form file:line:col. The file name is
+// omitted if unknown and an invalid position yields "-".
+func (p Pos) String() string {
+	switch {
+	case !p.IsValid():
+		return "-"
+	case p.File == "":
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+	}
+}
+
+func (p Pos) srcPos() vm.SrcPos {
+	return vm.SrcPos{File: p.File, Line: p.Line, Col: p.Col}
+}
+
+// Token is a single lexeme of the source text and its location.
+type Token struct {
+	Val string
+	Pos Pos
+}
+
+// PosOf returns the source position of a node. Bare values that have been
+// created by the compiler or the rewriters have no position. For them an
+// invalid position is returned.
+func PosOf(n Node) Pos {
+	switch x := n.(type) {
+	case *SymbolNode:
+		return x.Pos
+	case *LitNode:
+		return x.Pos
+	case *VectorNode:
+		return x.Pos
+	case *MapNode:
+		return x.Pos
+	case *ListNode:
+		return x.Pos
+	case *ErrorNode:
+		return x.Pos
+	}
+	return Pos{}
+}
+
+// FormatError prefixes an error message with the source position if the
+// position is known.
+func FormatError(pos Pos, msg string) string {
+	if pos.IsValid() {
+		return fmt.Sprintf("%s: %s", pos, msg)
+	}
+	return msg
+}
@@ -3,8 +3,14 @@ package cmp
 import (
 	"bytes"
 	"strconv"
+	"strings"
 )
 
+// maxWidth is the number of characters per line up to which PrettyPrintAst
+// prints nodes on a single line.
+const maxWidth = 80
+
+// PrintAst prints the node on a single line.
 func PrintAst(node Node) string {
 	var buf bytes.Buffer
 	printNode(&buf, node)
@@ -15,7 +21,7 @@ func printNode(buf *bytes.Buffer, node Node) {
 	switch x := node.(type) {
 	case *ErrorNode:
 		buf.WriteString("  [ERROR]  ")
-	case nil:
+	case Nil:
 		buf.WriteString("nil")
 	case bool:
 		buf.WriteString(strconv.FormatBool(x))
@@ -25,20 +31,26 @@ func printNode(buf *bytes.Buffer, node Node) {
 		buf.WriteString(strconv.FormatFloat(x, 'f', -1, 64))
 	case string:
 		printString(buf, x)
+	case Keyword:
+		buf.WriteString(":")
+		buf.WriteString(string(x))
 	case *SymbolNode:
 		buf.WriteString(x.Name)
+	case *LitNode:
+		printNode(buf, x.Val)
 	case *ListNode:
 		printSeq(buf, x.Items, "(", ")")
-	case []Node:
-		printSeq(buf, x, "[", "]")
-	case Map:
-		printHashMap(buf, x)
+	case *VectorNode:
+		printSeq(buf, x.Items, "[", "]")
+	case *MapNode:
+		printSeq(buf, x.Items, "{", "}")
 	}
 }
 
 func printString(buf *bytes.Buffer, s string) {
 	buf.WriteString(`"`)
-	buf.WriteString(s)
+	// The reader turns the escape sequence back into a newline.
+	buf.WriteString(strings.ReplaceAll(s, "\n", `\n`))
 	buf.WriteString(`"`)
 }
 
@@ -53,18 +65,79 @@ func printSeq(buf *bytes.Buffer, items []Node, start string, end string) {
 	buf.WriteString(end)
 }
 
-func printHashMap(buf *bytes.Buffer, m Map) {
-	buf.WriteString("{")
-	// TODO: Unfortunate.
-	init := false
-	for key, val := range m {
-		if init {
+// PrettyPrintAst prints the node like PrintAst but breaks lists, vectors
+// and maps that do not fit into a line. A broken list keeps its head and
+// its first argument on the first line. Every other item goes on a line of
+// its own and is indented by two spaces. The items of vectors and maps are
+// aligned with the first item.
+func PrettyPrintAst(node Node) string {
+	var buf bytes.Buffer
+	prettyNode(&buf, node, 0)
+	return buf.String()
+}
+
+func prettyNode(buf *bytes.Buffer, node Node, indent int) {
+	flat := PrintAst(node)
+	if indent+len(flat) <= maxWidth {
+		buf.WriteString(flat)
+		return
+	}
+	switch x := node.(type) {
+	case *ListNode:
+		prettyList(buf, x.Items, indent)
+	case *VectorNode:
+		prettySeq(buf, x.Items, "[", "]", indent)
+	case *MapNode:
+		prettySeq(buf, x.Items, "{", "}", indent)
+	default:
+		buf.WriteString(flat)
+	}
+}
+
+func prettyList(buf *bytes.Buffer, items []Node, indent int) {
+	buf.WriteString("(")
+	head := 0
+	if len(items) > 0 && IsSymbol(items[0]) {
+		printNode(buf, items[0])
+		head = 1
+		// Keep the first argument on the first line. For definitions like
+		// (defn name [params] body) keep the parameters as well.
+		for head < len(items)-1 && (head == 1 || head == 2 && isParams(items)) {
 			buf.WriteString(" ")
+			prettyNode(buf, items[head], column(buf))
+			head++
 		}
-		init = true
-		printNode(buf, key)
-		buf.WriteString(" ")
-		printNode(buf, val)
 	}
-	buf.WriteString("}")
+	for i, item := range items[head:] {
+		if head > 0 || i > 0 {
+			newline(buf, indent+2)
+		}
+		prettyNode(buf, item, indent+2)
+	}
+	buf.WriteString(")")
+}
+
+func isParams(items []Node) bool {
+	return IsSymbol(items[1]) && IsVector(items[2])
+}
+
+func prettySeq(buf *bytes.Buffer, items []Node, start string, end string, indent int) {
+	buf.WriteString(start)
+	for i, item := range items {
+		if i > 0 {
+			newline(buf, indent+1)
+		}
+		prettyNode(buf, item, indent+1)
+	}
+	buf.WriteString(end)
+}
+
+func newline(buf *bytes.Buffer, indent int) {
+	buf.WriteString("\n")
+	buf.WriteString(strings.Repeat(" ", indent))
+}
+
+// column returns the column of the next character written to buf.
+func column(buf *bytes.Buffer) int {
+	return buf.Len() - bytes.LastIndexByte(buf.Bytes(), '\n') - 1
 }
@@ -0,0 +1,43 @@
+package cmp_test
+
+import (
+	"testing"
+
+	"github.com/mhoertnagl/noodles/internal/cmp"
+)
+
+func TestPrintAstString(t *testing.T) {
+	testPrint(t, cmp.PrintAst, `(println "a\nb")`, `(println "a\nb")`)
+}
+
+func TestPrettyPrintAstShort(t *testing.T) {
+	testPrint(t, cmp.PrettyPrintAst, `(def x [1 2 {:a 3}])`, `(def x [1 2 {:a 3}])`)
+}
+
+func TestPrettyPrintAstList(t *testing.T) {
+	i := `(defn foo [some-argument another-argument] (if (< some-argument another-argument) (+ some-argument 1) (- another-argument 1)))`
+	e := `(defn foo [some-argument another-argument]
+  (if (< some-argument another-argument)
+    (+ some-argument 1)
+    (- another-argument 1)))`
+	testPrint(t, cmp.PrettyPrintAst, i, e)
+}
+
+func TestPrettyPrintAstVector(t *testing.T) {
+	i := `[:aaaaaaaaaaaaaaaaaaaa :bbbbbbbbbbbbbbbbbbbb :cccccccccccccccccccc :dddddddddddddddddddd]`
+	e := `[:aaaaaaaaaaaaaaaaaaaa
+ :bbbbbbbbbbbbbbbbbbbb
+ :cccccccccccccccccccc
+ :dddddddddddddddddddd]`
+	testPrint(t, cmp.PrettyPrintAst, i, e)
+}
+
+func testPrint(t *testing.T, print func(cmp.Node) string, i string, e string) {
+	t.Helper()
+	r := cmp.NewReader()
+	r.Load(i)
+	p := cmp.NewParser()
+	if a := print(p.Parse(r)); a != e {
+		t.Errorf("Expecting\n%s\nbut got\n%s", e, a)
+	}
+}
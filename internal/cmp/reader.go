@@ -3,14 +3,16 @@ package cmp
 import (
 	"bytes"
 	"regexp"
+	"unicode/utf8"
 )
 
 // Reader tokenizes the input string and provides methods to enumerate the
 // tokens sequentially.
 type Reader struct {
 	re     *regexp.Regexp
-	tokens []string
+	tokens []Token
 	pos    int
+	end    Pos
 }
 
 // NewReader creates a new Reader instance.
@@ -21,17 +23,45 @@ func NewReader() *Reader {
 	return r
 }
 
+// Load tokenizes an input string that does not originate from a file.
 func (r *Reader) Load(input string) {
-	mm := r.re.FindAllStringSubmatch(input, -1)
-	r.tokens = []string{}
+	r.LoadFile("", input)
+}
+
+// LoadFile tokenizes the contents of the file. The file name will be attached
+// to the positions of all tokens.
+func (r *Reader) LoadFile(file string, input string) {
+	mm := r.re.FindAllStringSubmatchIndex(input, -1)
+	r.tokens = []Token{}
+	// Track the line and column while advancing through the input.
+	cur := Pos{File: file, Line: 1, Col: 1}
+	off := 0
 	for _, m := range mm {
-		if m[1] != "" {
-			r.tokens = append(r.tokens, m[1])
+		if m[2] >= 0 && m[3] > m[2] {
+			cur = advance(cur, input[off:m[2]])
+			off = m[2]
+			r.tokens = append(r.tokens, Token{Val: input[m[2]:m[3]], Pos: cur})
 		}
 	}
+	r.end = advance(cur, input[off:])
 	r.pos = 0
 }
 
+// advance moves the position past the text s.
+func advance(p Pos, s string) Pos {
+	for len(s) > 0 {
+		c, sz := utf8.DecodeRuneInString(s)
+		if c == '\n' {
+			p.Line++
+			p.Col = 1
+		} else {
+			p.Col++
+		}
+		s = s[sz:]
+	}
+	return p
+}
+
 func (r *Reader) Next() string {
 	t := r.Peek()
 	r.pos++
@@ -40,28 +70,33 @@ func (r *Reader) Next() string {
 
 func (r *Reader) Peek() string {
 	if r.pos < len(r.tokens) {
-		return r.tokens[r.pos]
+		return r.tokens[r.pos].Val
 	}
 	return ""
 }
 
-func (r *Reader) Pos() int {
-	// Positions visible to the user start at 1.
-	return r.pos + 1
+// Pos returns the position of the token that has been returned by the last
+// call to Next. Past the last token it returns the position of the end of the
+// input.
+func (r *Reader) Pos() Pos {
+	if r.pos > 0 && r.pos <= len(r.tokens) {
+		return r.tokens[r.pos-1].Pos
+	}
+	return r.end
 }
 
 // https://regex101.com/r/Awgqpk/1
 func buildPattern() string {
 	var pat bytes.Buffer
-	pat.WriteString(`[\s,]*`)                     // whitespace or commas
-	pat.WriteString("(")                          // Begin capture group
-	pat.WriteString("[\\[\\]{}\\(\\)'~^@]")       // any of [, ], {, }, (, ), ', ~, ^, @
-	pat.WriteString("|")                          // or
-	pat.WriteString(`"(?:\\"|[^"])*"?`)           // strings with escape characters and an optional " at the end
-	pat.WriteString("|")                          // or
-	pat.WriteString("[^\\s\\[\\]{}\\('\",;\\)]+") // symbols (including numbers)
-	pat.WriteString(")")                          // End capture group
-	pat.WriteString("|")                          // or
-	pat.WriteString(";[^\n]*(?:$|\n)")            // comments
+	pat.WriteString(`[\s,]*`)                      // whitespace or commas
+	pat.WriteString("(")                           // Begin capture group
+	pat.WriteString("[\\[\\]{}\\(\\)'`~^@]")       // any of [, ], {, }, (, ), ', `, ~, ^, @
+	pat.WriteString("|")                           // or
+	pat.WriteString(`"(?:\\"|[^"])*"?`)            // strings with escape characters and an optional " at the end
+	pat.WriteString("|")                           // or
+	pat.WriteString("[^\\s\\[\\]{}\\('`\",;\\)]+") // symbols (including numbers)
+	pat.WriteString(")")                           // End capture group
+	pat.WriteString("|")                           // or
+	pat.WriteString(";[^\n]*(?:$|\n)")             // comments
 	return pat.String()
 }
@@ -71,6 +71,27 @@ func TestProg(t *testing.T) {
 		"(", "if", "x", "false", "true", ")", ")", ")", "")
 }
 
+func TestPositions(t *testing.T) {
+	src := "(+ 1\n  ;; Comment\n  \"ä\" x)"
+	r := cmp.NewReader()
+	r.LoadFile("test.splis", src)
+	exp := []string{
+		"test.splis:1:1",
+		"test.splis:1:2",
+		"test.splis:1:4",
+		"test.splis:3:3",
+		"test.splis:3:7",
+		"test.splis:3:8",
+		"test.splis:3:9",
+	}
+	for idx, e := range exp {
+		r.Next()
+		if a := r.Pos().String(); a != e {
+			t.Errorf("Expecting [%s] at pos [%d] but got [%s]", e, idx+1, a)
+		}
+	}
+}
+
 func testr(t *testing.T, i string, es ...string) {
 	r := cmp.NewReader()
 	r.Load(i)
@@ -10,4 +10,25 @@ func (c *Compiler) AddDefaultGlobals() {
 	c.AddGlobal("*STD-IN*")
 	c.AddGlobal("*STD-OUT*")
 	c.AddGlobal("*STD-ERR*")
+	c.AddGlobal("*ARGS*")
+}
+
+// NumGlobals returns the number of global definitions.
+func (c *Compiler) NumGlobals() int {
+	return int(c.defs.index)
+}
+
+// TruncateGlobals removes the global definitions with IDs from n onwards.
+// It rolls back the definitions of a failed compilation.
+func (c *Compiler) TruncateGlobals(n int) {
+	c.defs.truncate(uint64(n))
+}
+
+// Globals returns the names of all global definitions indexed by their IDs.
+func (c *Compiler) Globals() []string {
+	names := make([]string, c.defs.index)
+	for id, name := range c.defs.names {
+		names[id] = name
+	}
+	return names
 }
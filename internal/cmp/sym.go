@@ -7,30 +7,86 @@ import (
 
 type SymEntry struct {
 	idx int
+	// cell is true if the slot holds a cell that holds the value.
+	cell bool
 }
 
 type symMap map[string]*SymEntry
 
+// SymTable maps the local bindings of a scope to their slots in the frame of
+// the enclosing function. A function has a frame of its own while the scope of
+// a let binding shares the frame with its parent. The slots of a scope follow
+// the slots of its parent.
 type SymTable struct {
 	// Name    string
 	parent  *SymTable
 	entries symMap
+	// scope is true if the table shares the frame of its parent.
+	scope bool
+	// base is the index of the first slot of the table in the frame.
+	base int
+	// size is the number of slots of the table. A binding that shadows another
+	// binding in the same table occupies a slot of its own.
+	size int
+	// cells contains the names of the bindings of the frame that live in
+	// cells. Nested scopes share the names with their frame.
+	cells map[string]bool
 }
 
 func NewSymTable() *SymTable {
 	return &SymTable{
 		parent:  nil,
 		entries: make(symMap),
+		cells:   make(map[string]bool),
 	}
 }
 
+// NewSymTable creates the table of a function that is defined in the scope
+// of s.
 func (s *SymTable) NewSymTable() *SymTable {
 	return &SymTable{
 		parent:  s,
 		entries: make(symMap),
+		cells:   make(map[string]bool),
 	}
 }
 
+// NewScope creates a nested scope that shares the frame of s. Its bindings
+// shadow the bindings of s. The slots of the scope have to be dropped when
+// the scope ends.
+func (s *SymTable) NewScope() *SymTable {
+	return &SymTable{
+		parent:  s,
+		entries: make(symMap),
+		scope:   true,
+		base:    s.base + s.size,
+		cells:   s.cells,
+	}
+}
+
+// AddCells declares that the bindings ns of the frame live in cells. This
+// affects bindings that are added afterwards only.
+func (s *SymTable) AddCells(ns ...string) {
+	for _, n := range ns {
+		s.cells[n] = true
+	}
+}
+
+// NeedsCell returns true if a binding n added to s will live in a cell.
+func (s *SymTable) NeedsCell(n string) bool {
+	return s.cells[n]
+}
+
+// IsCell returns true if the binding n lives in a cell.
+func (s *SymTable) IsCell(n string) bool {
+	for c := s; c != nil; c = c.parent {
+		if e, ok := c.entries[n]; ok {
+			return e.cell
+		}
+	}
+	return false
+}
+
 // func (s *SymTable) NewClosureSymTable(eps []*SymbolNode) *SymTable {
 // 	cs := &SymTable{
 // 		parent:  s,
@@ -71,8 +127,14 @@ func (s *SymTable) NewSymTable() *SymTable {
 // 	return cs
 // }
 
+// Size returns the number of slots of the table.
 func (s *SymTable) Size() int {
-	return len(s.entries)
+	return s.size
+}
+
+// frameSize returns the number of slots in use in the frame of the table.
+func (s *SymTable) frameSize() int {
+	return s.base + s.size
 }
 
 func (s *SymTable) AddVar(ns ...string) {
@@ -80,11 +142,12 @@ func (s *SymTable) AddVar(ns ...string) {
 }
 
 func (s *SymTable) Add(ns []string) {
-	sz := s.Size()
-	for idx, n := range ns {
+	for _, n := range ns {
 		s.entries[n] = &SymEntry{
-			idx: sz + idx,
+			idx:  s.frameSize(),
+			cell: s.cells[n],
 		}
+		s.size++
 	}
 }
 
@@ -102,16 +165,6 @@ func (s *SymTable) Add(ns []string) {
 // 	}
 // }
 
-func (s *SymTable) RemoveVar(ns ...string) {
-	s.Remove(ns)
-}
-
-func (s *SymTable) Remove(ns []string) {
-	for _, n := range ns {
-		delete(s.entries, n)
-	}
-}
-
 // func (s *SymTable) Find(n string) (*SymEntry, bool) {
 // 	for c := s; c != nil; c = c.parent {
 // 		if e, ok := c.entries[n]; ok {
@@ -121,16 +174,18 @@ func (s *SymTable) Remove(ns []string) {
 // 	return nil, false
 // }
 
+// IndexOf returns the index of the slot of the binding n relative to the
+// frame of s. Bindings of enclosing functions have negative indexes.
 func (s *SymTable) IndexOf(n string) (int, bool) {
 	dfp := 0
 	for c := s; c != nil; c = c.parent {
 		if e, ok := c.entries[n]; ok {
 			return dfp + e.idx, true
 		}
-		// Subtract the FP and the RP cell as well as the number of arguments
-		// of the current frame.
-		if c.parent != nil {
-			dfp -= (2 + c.parent.Size())
+		// Subtract the FP and the RP cell as well as the number of slots of the
+		// enclosing frame. Nested scopes share the frame.
+		if c.parent != nil && !c.scope {
+			dfp -= (2 + c.parent.frameSize())
 		}
 	}
 	return 0, false
@@ -22,6 +22,22 @@ func TestSymIndexOf1(t *testing.T) {
 	testIndexOf(t, sub, "min", -4)
 }
 
+func TestSymIndexOfScope(t *testing.T) {
+	sym := cmp.NewSymTable()
+	sym.AddVar("a", "b")
+	scope := sym.NewScope()
+	scope.AddVar("a", "c", "c")
+	testIndexOf(t, scope, "a", 2)
+	testIndexOf(t, scope, "b", 1)
+	testIndexOf(t, scope, "c", 4)
+	testIndexOf(t, sym, "a", 0)
+	sub := scope.NewSymTable()
+	sub.AddVar("x")
+	testIndexOf(t, sub, "x", 0)
+	testIndexOf(t, sub, "a", -5)
+	testIndexOf(t, sub, "b", -6)
+}
+
 func testIndexOf(t *testing.T, sym *cmp.SymTable, n string, e int) {
 	t.Helper()
 	a, _ := sym.IndexOf(n)
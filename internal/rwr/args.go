@@ -18,7 +18,7 @@ func NewArgsRewriter(man []string, opt string, args []cmp.Node) *ArgsRewriter {
 	}
 
 	if opt != "" {
-		ams[opt] = args[len(man):]
+		ams[opt] = cmp.NewVector(args[len(man):])
 	}
 
 	return &ArgsRewriter{ams: ams}
@@ -28,8 +28,10 @@ func (r *ArgsRewriter) Rewrite(n cmp.Node) cmp.Node {
 	switch x := n.(type) {
 	case *cmp.SymbolNode:
 		return r.rewriteSymbol(x)
-	case []cmp.Node:
-		return RewriteItems(r, x)
+	case *cmp.VectorNode:
+		return cmp.NewVectorAt(RewriteItems(r, x.Items), x.Pos)
+	case *cmp.MapNode:
+		return cmp.NewMapAt(RewriteItems(r, x.Items), x.Pos)
 	case *cmp.ListNode:
 		return r.rewriteList(x)
 	default:
@@ -53,14 +55,14 @@ func (r *ArgsRewriter) rewriteList(n *cmp.ListNode) cmp.Node {
 			l = append(l, r.Rewrite(a))
 		}
 	}
-	return cmp.NewList(l)
+	return cmp.NewListAt(l, n.Pos)
 }
 
 func (r *ArgsRewriter) rewriteListArg(a *cmp.ListNode) []cmp.Node {
 	if cmp.IsCall(a, "dissolve") {
 		k := r.Rewrite(a.Items[1])
-		if kl, ok := k.([]cmp.Node); ok {
-			return kl
+		if kl, ok := k.(*cmp.VectorNode); ok {
+			return kl.Items
 		}
 	}
 	return []cmp.Node{r.Rewrite(a)}
@@ -0,0 +1,145 @@
+package rwr
+
+import (
+	"fmt"
+
+	"github.com/mhoertnagl/noodles/internal/cmp"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// converter converts code into data and back. It remembers the nodes that
+// it has converted into lists, maps and symbols. If such a value is converted
+// back unchanged, the original node is used again so that it keeps its source
+// position. Any other node takes the position pos. Symbols that have not been
+// converted from nodes before are introduced.
+type converter struct {
+	pos        cmp.Pos
+	nodes      map[vm.Val]cmp.Node
+	introduced map[*cmp.SymbolNode]bool
+}
+
+func newConverter(pos cmp.Pos) *converter {
+	return &converter{
+		pos:        pos,
+		nodes:      map[vm.Val]cmp.Node{},
+		introduced: map[*cmp.SymbolNode]bool{},
+	}
+}
+
+// ToVal converts the code n into a runtime value. Symbols become symbols,
+// lists become lists and vectors become vectors.
+func ToVal(n cmp.Node) vm.Val {
+	return newConverter(cmp.Pos{}).toVal(n)
+}
+
+// ToNode converts the runtime value v into code. New nodes take the position
+// pos. Values that have no representation as code like functions cannot be
+// converted.
+func ToNode(v vm.Val, pos cmp.Pos) (cmp.Node, error) {
+	return newConverter(pos).toNode(v)
+}
+
+func (c *converter) toVal(n cmp.Node) vm.Val {
+	switch x := n.(type) {
+	case cmp.Nil:
+		return vm.Nil{}
+	case cmp.Keyword:
+		return vm.Intern(string(x))
+	case *cmp.SymbolNode:
+		v := vm.NewSymbol(x.Name)
+		c.nodes[v] = n
+		return v
+	case *cmp.LitNode:
+		return c.toVal(x.Val)
+	case *cmp.VectorNode:
+		return c.toVals(x.Items)
+	case *cmp.ListNode:
+		v := vm.NewList(c.toVals(x.Items))
+		c.nodes[v] = n
+		return v
+	case *cmp.MapNode:
+		v := vm.NewMap()
+		for i := 0; i+1 < len(x.Items); i += 2 {
+			v = v.Assoc(c.toVal(x.Items[i]), c.toVal(x.Items[i+1]))
+		}
+		c.nodes[v] = n
+		return v
+	default:
+		// Booleans, numbers and strings are the same in both worlds.
+		return n
+	}
+}
+
+func (c *converter) toVals(ns []cmp.Node) []vm.Val {
+	vs := make([]vm.Val, len(ns))
+	for i, n := range ns {
+		vs[i] = c.toVal(n)
+	}
+	return vs
+}
+
+func (c *converter) toNode(v vm.Val) (cmp.Node, error) {
+	switch x := v.(type) {
+	case bool, int64, float64, string:
+		return cmp.NewLitAt(x, c.pos), nil
+	case vm.Nil:
+		return cmp.NewLitAt(cmp.Nil{}, c.pos), nil
+	case *vm.Keyword:
+		return cmp.NewLitAt(cmp.Keyword(x.Name), c.pos), nil
+	case *vm.Symbol:
+		if n, ok := c.nodes[x]; ok {
+			return n, nil
+		}
+		s := cmp.NewSymbolAt(x.Name, c.pos)
+		c.introduced[s] = true
+		return s, nil
+	case []vm.Val:
+		ns, err := c.toNodes(x)
+		if err != nil {
+			return nil, err
+		}
+		return cmp.NewVectorAt(ns, c.pos), nil
+	case *vm.List:
+		if n, ok := c.nodes[x]; ok {
+			return n, nil
+		}
+		ns, err := c.toNodes(x.Items)
+		if err != nil {
+			return nil, err
+		}
+		return cmp.NewListAt(ns, c.pos), nil
+	case *vm.Map:
+		if n, ok := c.nodes[x]; ok {
+			return n, nil
+		}
+		ks := x.Keys()
+		vs := x.Vals()
+		ns := []cmp.Node{}
+		for i := range ks {
+			k, err := c.toNode(ks[i])
+			if err != nil {
+				return nil, err
+			}
+			v, err := c.toNode(vs[i])
+			if err != nil {
+				return nil, err
+			}
+			ns = append(ns, k, v)
+		}
+		return cmp.NewMapAt(ns, c.pos), nil
+	default:
+		return nil, fmt.Errorf("cannot convert [%s] into code", vm.TypeName(v))
+	}
+}
+
+func (c *converter) toNodes(vs []vm.Val) ([]cmp.Node, error) {
+	ns := make([]cmp.Node, len(vs))
+	for i, v := range vs {
+		n, err := c.toNode(v)
+		if err != nil {
+			return nil, err
+		}
+		ns[i] = n
+	}
+	return ns, nil
+}
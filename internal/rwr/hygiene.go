@@ -0,0 +1,205 @@
+package rwr
+
+import (
+	"fmt"
+
+	"github.com/mhoertnagl/noodles/internal/cmp"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// gensym creates a fresh symbol. The optional argument is the prefix of the
+// name of the symbol. Generated names contain a # and thus never collide with
+// the names that a program uses.
+//
+//	(gensym)         >> G#1
+//	(gensym "start") >> start#2
+func (r *MacroRewriter) gensym(args []vm.Val) (vm.Val, error) {
+	prefix := "G"
+	switch len(args) {
+	case 0:
+	case 1:
+		switch x := args[0].(type) {
+		case string:
+			prefix = x
+		case *vm.Symbol:
+			prefix = x.Name
+		default:
+			return nil, fmt.Errorf("expects a string or a symbol but got [%s]", vm.TypeName(x))
+		}
+	default:
+		return nil, fmt.Errorf("expects at most [1] argument but got [%d]", len(args))
+	}
+	return vm.NewSymbol(r.freshName(prefix)), nil
+}
+
+func (r *MacroRewriter) freshName(prefix string) string {
+	r.gensyms++
+	return fmt.Sprintf("%s#%d", prefix, r.gensyms)
+}
+
+// hygiene renames the symbols that the macro has introduced into the code n
+// and that are bound by a set, let, letrec, loop, fn or catch form of the
+// introduced code. Only the bound symbols within the scope of their binding
+// are renamed. The names of the bindings thus cannot capture or clobber the
+// names of the code at the call site. The symbols of the macro arguments and
+// the free symbols that the macro has introduced are left untouched.
+func (r *MacroRewriter) hygiene(n cmp.Node, introduced map[*cmp.SymbolNode]bool) {
+	h := &hygiene{r: r, introduced: introduced}
+	h.walk(n, newScope(nil))
+}
+
+type hygiene struct {
+	r          *MacroRewriter
+	introduced map[*cmp.SymbolNode]bool
+}
+
+// scope maps the names of the introduced bindings to their fresh names.
+type scope struct {
+	outer *scope
+	names map[string]string
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, names: map[string]string{}}
+}
+
+func (s *scope) lookup(name string) (string, bool) {
+	for ; s != nil; s = s.outer {
+		if n, ok := s.names[name]; ok {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// bind renames the binding n if the macro has introduced it. The new name is
+// visible in the scope s.
+func (h *hygiene) bind(n cmp.Node, s *scope) {
+	sym, ok := n.(*cmp.SymbolNode)
+	if !ok || !h.introduced[sym] || sym.Name == "&" {
+		return
+	}
+	name := h.r.freshName(sym.Name)
+	s.names[sym.Name] = name
+	sym.Name = name
+}
+
+// walk renames the introduced symbols of n that refer to a binding of the
+// scope s or one of its outer scopes.
+func (h *hygiene) walk(n cmp.Node, s *scope) {
+	switch x := n.(type) {
+	case *cmp.SymbolNode:
+		if !h.introduced[x] {
+			return
+		}
+		if name, ok := s.lookup(x.Name); ok {
+			x.Name = name
+		}
+	case *cmp.VectorNode:
+		h.walkAll(x.Items, s)
+	case *cmp.MapNode:
+		h.walkAll(x.Items, s)
+	case *cmp.ListNode:
+		h.walkList(x, s)
+	}
+}
+
+func (h *hygiene) walkAll(ns []cmp.Node, s *scope) {
+	for _, n := range ns {
+		h.walk(n, s)
+	}
+}
+
+func (h *hygiene) walkList(l *cmp.ListNode, s *scope) {
+	switch {
+	case cmp.IsCall(l, "quote"):
+	case cmp.IsCall(l, "set") && l.Len() == 3:
+		// The binding is visible in its own value and in the rest of the
+		// enclosing scope.
+		h.bind(l.Items[1], s)
+		h.walk(l.Items[2], s)
+	case cmp.IsCall(l, "let") && l.Len() > 1 && isList(l.Items[1]):
+		sub := newScope(s)
+		bs := l.Items[1].(*cmp.ListNode).Items
+		for i := 0; i+1 < len(bs); i += 2 {
+			// Functions see their own binding.
+			if cmp.IsCallN(bs[i+1], "fn") {
+				h.bind(bs[i], sub)
+				h.walk(bs[i+1], sub)
+			} else {
+				h.walk(bs[i+1], sub)
+				h.bind(bs[i], sub)
+			}
+		}
+		h.walkAll(l.Items[2:], sub)
+	case cmp.IsCall(l, "letrec") && l.Len() > 1 && isList(l.Items[1]):
+		// All bindings are visible to all values.
+		sub := newScope(s)
+		bs := bindings(l.Items[1])
+		for i := 0; i < len(bs); i += 2 {
+			h.bind(bs[i], sub)
+		}
+		for i := 1; i < len(bs); i += 2 {
+			h.walk(bs[i], sub)
+		}
+		h.walkAll(l.Items[2:], sub)
+	case cmp.IsCall(l, "loop") && l.Len() > 1 && (isList(l.Items[1]) || cmp.IsVector(l.Items[1])):
+		sub := newScope(s)
+		bs := bindings(l.Items[1])
+		for i := 0; i+1 < len(bs); i += 2 {
+			h.walk(bs[i+1], sub)
+			h.bind(bs[i], sub)
+		}
+		h.walkAll(l.Items[2:], sub)
+	case cmp.IsCall(l, "fn") && l.Len() > 1 && cmp.IsVector(l.Items[1]):
+		h.walkClause(l.Items[1].(*cmp.VectorNode).Items, l.Items[2:], s)
+	case cmp.IsCall(l, "fn") && l.Len() > 1:
+		for _, c := range l.Items[1:] {
+			if cl, ok := c.(*cmp.ListNode); ok && cl.Len() > 0 && cmp.IsVector(cl.Items[0]) {
+				h.walkClause(cl.Items[0].(*cmp.VectorNode).Items, cl.Items[1:], s)
+			} else {
+				h.walk(c, s)
+			}
+		}
+	case cmp.IsCall(l, "try") && l.Len() == 3 && cmp.IsCallN(l.Items[2], "catch"):
+		h.walk(l.Items[1], s)
+		c := l.Items[2].(*cmp.ListNode)
+		if c.Len() != 3 {
+			h.walkAll(c.Items, s)
+			return
+		}
+		sub := newScope(s)
+		h.bind(c.Items[1], sub)
+		h.walk(c.Items[2], sub)
+	default:
+		h.walkAll(l.Items, s)
+	}
+}
+
+// walkClause renames the parameters of a function clause in its body. A
+// default value sees the preceding parameters.
+func (h *hygiene) walkClause(params []cmp.Node, body []cmp.Node, s *scope) {
+	sub := newScope(s)
+	for _, p := range params {
+		if d, ok := p.(*cmp.ListNode); ok && d.Len() == 2 {
+			h.walk(d.Items[1], sub)
+			h.bind(d.Items[0], sub)
+		} else {
+			h.bind(p, sub)
+		}
+	}
+	h.walkAll(body, sub)
+}
+
+// bindings returns the items of a list or vector of bindings.
+func bindings(n cmp.Node) []cmp.Node {
+	if l, ok := n.(*cmp.ListNode); ok {
+		return l.Items
+	}
+	return n.(*cmp.VectorNode).Items
+}
+
+func isList(n cmp.Node) bool {
+	_, ok := n.(*cmp.ListNode)
+	return ok
+}
@@ -3,36 +3,97 @@ package rwr
 import (
 	"fmt"
 
+	"github.com/mhoertnagl/noodles/internal/asm"
 	"github.com/mhoertnagl/noodles/internal/cmp"
 	"github.com/mhoertnagl/noodles/internal/util"
+	"github.com/mhoertnagl/noodles/internal/vm"
 )
 
 type macroDefs map[string]*macroDef
 
+// macroDef is a macro definition. The macro is a function on the compile time
+// machine.
 type macroDef struct {
-	man  []string
-	opt  string
-	body cmp.Node
+	man []string
+	opt string
+	fn  vm.Val
 }
 
+// MacroRewriter expands macros. A macro is a function that is executed at
+// compile time. It receives the code of its arguments as data and returns
+// the code that replaces the macro invocation.
+//
+// Macros may call the primitives, the other macros and the functions and
+// constants that the top level forms seen so far define. Top level forms are
+// the forms of the program and of the used modules that are not nested in
+// any other form but do. Definitions with values other than functions and
+// literals are not available to macros since evaluating them at compile time
+// could have side effects.
 type MacroRewriter struct {
-	macros macroDefs
-	err    []string
+	macros  macroDefs
+	pos     cmp.Pos
+	err     []string
+	gensyms int
+	cmp     *cmp.Compiler
+	asm     *asm.Assembler
+	vm      *vm.VM
+	// depth is the nesting depth of the form that is being rewritten. It is 0
+	// for top level forms.
+	depth int
 }
 
 func NewMacroRewriter() *MacroRewriter {
-	return &MacroRewriter{
+	r := &MacroRewriter{
 		macros: macroDefs{},
 		err:    make([]string, 0),
+		cmp:    cmp.NewCompiler(),
+		asm:    asm.NewAssembler(),
+		vm:     vm.NewVM(1024, 0, 512),
 	}
+	// Macros may print to the standard streams at compile time.
+	r.cmp.AddDefaultGlobals()
+	r.vm.AddDefaultGlobals()
+	r.register(vm.NewNative("gensym", vm.Variadic, r.gensym))
+	return r
+}
+
+// register makes the native function n available to macros.
+func (r *MacroRewriter) register(n *vm.Native) {
+	id := r.cmp.AddGlobal(n.Name)
+	r.vm.AddGlobal(id, n)
 }
 
 func (r *MacroRewriter) Errors() []string {
 	return r.err
 }
 
+// MacroSnapshot is the state of a MacroRewriter at some point.
+type MacroSnapshot struct {
+	macros macroDefs
+}
+
+// Snapshot returns the current state of the rewriter.
+func (r *MacroRewriter) Snapshot() MacroSnapshot {
+	ms := macroDefs{}
+	for name, def := range r.macros {
+		ms[name] = def
+	}
+	return MacroSnapshot{macros: ms}
+}
+
+// Restore forgets the macros that have been defined or redefined since the
+// snapshot s was taken. It rolls back the macros of a failed compilation.
+func (r *MacroRewriter) Restore(s MacroSnapshot) {
+	r.macros = macroDefs{}
+	for name, def := range s.macros {
+		r.macros[name] = def
+	}
+}
+
+// error records an error at the position of the macro definition that is
+// currently being processed.
 func (r *MacroRewriter) error(format string, args ...interface{}) {
-	e := fmt.Sprintf(format, args...)
+	e := cmp.FormatError(r.pos, fmt.Sprintf(format, args...))
 	r.err = append(r.err, e)
 }
 
@@ -40,19 +101,39 @@ func (r *MacroRewriter) Rewrite(n cmp.Node) cmp.Node {
 	switch x := n.(type) {
 	case *cmp.SymbolNode:
 		if def, ok := r.macros[x.Name]; ok {
-			rw := NewArgsRewriter(def.man, def.opt, []cmp.Node{})
-			return r.Rewrite(rw.Rewrite(def.body))
+			return r.expand(x.Name, def, []cmp.Node{}, x.Pos)
 		}
 		return n
-	case []cmp.Node:
-		return RewriteItems(r, x)
+	case *cmp.VectorNode:
+		return cmp.NewVectorAt(RewriteItems(r, x.Items), x.Pos)
+	case *cmp.MapNode:
+		return cmp.NewMapAt(RewriteItems(r, x.Items), x.Pos)
 	case *cmp.ListNode:
+		if r.depth == 0 {
+			return r.rewriteTop(x)
+		}
 		return r.rewriteList(x)
 	default:
 		return n
 	}
 }
 
+// rewriteTop rewrites the top level form n. The forms of a top level do form
+// are top level forms themselves. Top level definitions are evaluated on the
+// compile time machine as well.
+func (r *MacroRewriter) rewriteTop(n *cmp.ListNode) cmp.Node {
+	if cmp.IsCall(n, "do") {
+		return cmp.NewListAt(append(n.Items[:1:1], RewriteItems(r, n.Items[1:])...), n.Pos)
+	}
+	r.depth++
+	m := r.rewriteList(n)
+	r.depth--
+	if cmp.IsCallN(m, "def") {
+		r.define(m.(*cmp.ListNode))
+	}
+	return m
+}
+
 func (r *MacroRewriter) rewriteList(n *cmp.ListNode) cmp.Node {
 	if len(n.Items) == 0 {
 		return n
@@ -60,17 +141,92 @@ func (r *MacroRewriter) rewriteList(n *cmp.ListNode) cmp.Node {
 	switch x := n.Items[0].(type) {
 	case *cmp.SymbolNode:
 		switch x.Name {
+		case "quasiquote":
+			// A quasi-quoted form is data. Macros are only expanded in its
+			// unquoted forms.
+			return RewriteUnquoted(r, n)
 		case "defmacro":
+			r.pos = n.Pos
+			if len(n.Items) != 4 {
+				r.error("[defmacro] requires exactly three arguments")
+				return nil
+			}
 			r.addMacro(n.Items[1], n.Items[2], n.Items[3])
 			return nil
+		case "macroexpand-1", "macroexpand":
+			// The expansion of the form is the result as data.
+			r.pos = n.Pos
+			if len(n.Items) != 2 {
+				r.error("[%s] requires exactly one argument", x.Name)
+				return nil
+			}
+			var m cmp.Node
+			if x.Name == "macroexpand-1" {
+				m = r.Expand1(n.Items[1])
+			} else {
+				m = r.Rewrite(n.Items[1])
+			}
+			if m == nil {
+				return cmp.Nil{}
+			}
+			return cmp.Quasiquote(m)
 		default:
 			if def, ok := r.macros[x.Name]; ok {
-				rw := NewArgsRewriter(def.man, def.opt, n.Items[1:])
-				return r.Rewrite(rw.Rewrite(def.body))
+				return r.expand(x.Name, def, n.Items[1:], n.Pos)
 			}
 		}
 	}
-	return cmp.NewList(RewriteItems(r, n.Items))
+	return cmp.NewListAt(RewriteItems(r, n.Items), n.Pos)
+}
+
+// expand calls the macro with the code of the arguments of the macro
+// invocation at position pos and rewrites the resulting code. New nodes of
+// the resulting code take the position of the macro invocation while the
+// arguments keep their own positions.
+func (r *MacroRewriter) expand(name string, def *macroDef, args []cmp.Node, pos cmp.Pos) cmp.Node {
+	return r.Rewrite(r.expand1(name, def, args, pos))
+}
+
+// Expand1 expands the macro invocation n exactly once. Macros in the
+// resulting code are not expanded. Any other node is returned unchanged.
+// Use Rewrite to expand all macros.
+func (r *MacroRewriter) Expand1(n cmp.Node) cmp.Node {
+	switch x := n.(type) {
+	case *cmp.SymbolNode:
+		if def, ok := r.macros[x.Name]; ok {
+			return r.expand1(x.Name, def, []cmp.Node{}, x.Pos)
+		}
+	case *cmp.ListNode:
+		if len(x.Items) > 0 {
+			if s, ok := x.Items[0].(*cmp.SymbolNode); ok {
+				if def, ok := r.macros[s.Name]; ok {
+					return r.expand1(s.Name, def, x.Items[1:], x.Pos)
+				}
+			}
+		}
+	}
+	return n
+}
+
+func (r *MacroRewriter) expand1(name string, def *macroDef, args []cmp.Node, pos cmp.Pos) cmp.Node {
+	r.pos = pos
+	if len(args) < len(def.man) || (def.opt == "" && len(args) > len(def.man)) {
+		r.error("[%s] expects [%d] arguments but got [%d]", name, len(def.man), len(args))
+		return nil
+	}
+	c := newConverter(pos)
+	v, err := r.vm.Call(def.fn, c.toVals(args)...)
+	if err != nil {
+		r.error("[%s] %v", name, err)
+		return nil
+	}
+	n, err := c.toNode(v)
+	if err != nil {
+		r.error("[%s] %v", name, err)
+		return nil
+	}
+	r.hygiene(n, c.introduced)
+	return n
 }
 
 func (r *MacroRewriter) addMacro(name cmp.Node, pars cmp.Node, body cmp.Node) {
@@ -86,21 +242,75 @@ func (r *MacroRewriter) addMacro2(name string, pars cmp.Node, body cmp.Node) {
 		r.error("[defmacro] macro [%s] redefined", name)
 	}
 
-	man, opt := r.getParamNames(pars)
+	vec, ok := pars.(*cmp.VectorNode)
+	if !ok {
+		r.error("[defmacro] argument 2 has to be a vector of symbols")
+		return
+	}
+	params := vec.Items
+	man, opt := r.extractParams(params)
+
+	// The body may use macros itself. Expand them before the body gets
+	// compiled.
+	fn := cmp.Fn(params, r.Rewrite(body))
+	fn.Pos = r.pos
+	v, ok := r.eval(fn)
+	if !ok {
+		return
+	}
 
 	r.macros[name] = &macroDef{
-		man:  man,
-		opt:  opt,
-		body: body,
+		man: man,
+		opt: opt,
+		fn:  v,
+	}
+}
+
+// define evaluates the definition n on the compile time machine if its value
+// is a function or a literal. Definitions that cannot be compiled, for
+// instance because they call native functions of the program, are skipped.
+// A macro that calls them fails with an unknown symbol.
+func (r *MacroRewriter) define(n *cmp.ListNode) {
+	if n.Len() != 3 || !isConstant(n.Items[2]) {
+		return
+	}
+	nc := len(r.cmp.Errors())
+	ng := r.cmp.NumGlobals()
+	code := r.cmp.Compile(n)
+	if len(r.cmp.Errors()) > nc {
+		r.cmp.TruncateGlobals(ng)
+		return
+	}
+	r.vm.ReserveGlobals(r.cmp.NumGlobals())
+	r.vm.Reset()
+	r.vm.Append(r.asm.AssembleAt(code, uint64(r.vm.CodeSize())))
+}
+
+// isConstant returns true if evaluating n has no side effects.
+func isConstant(n cmp.Node) bool {
+	switch cmp.Lit(n).(type) {
+	case cmp.Nil, bool, int64, float64, string, cmp.Keyword:
+		return true
 	}
+	return cmp.IsCallN(n, "fn")
 }
 
-func (r *MacroRewriter) getParamNames(parsNode cmp.Node) ([]string, string) {
-	if params, ok := parsNode.([]cmp.Node); ok {
-		return r.extractParams(params)
+// eval compiles and runs the code n on the compile time machine and returns
+// its value.
+func (r *MacroRewriter) eval(n cmp.Node) (vm.Val, bool) {
+	nc := len(r.cmp.Errors())
+	code := r.cmp.Compile(n)
+	if errs := r.cmp.Errors()[nc:]; len(errs) > 0 {
+		r.err = append(r.err, errs...)
+		return nil, false
+	}
+	r.vm.ReserveGlobals(r.cmp.NumGlobals())
+	r.vm.Reset()
+	if err := r.vm.Append(r.asm.AssembleAt(code, uint64(r.vm.CodeSize()))); err != nil {
+		r.error("%v", err)
+		return nil, false
 	}
-	r.error("[defmacro] argument 2 has to be a vector of symbols")
-	return []string{}, ""
+	return r.vm.InspectStack(0), true
 }
 
 func (r *MacroRewriter) extractParams(params []cmp.Node) ([]string, string) {
@@ -133,7 +343,7 @@ func (r *MacroRewriter) verifyParam(param cmp.Node, pos int) string {
 	case *cmp.SymbolNode:
 		return sym.Name
 	default:
-		r.error(fmt.Sprintf("[fn] parameter at position [%d] is not a symbol", pos))
+		r.error("[fn] parameter at position [%d] is not a symbol", pos)
 		return ""
 	}
 }
@@ -20,8 +20,12 @@ func (r *QuoteRewriter) Rewrite(n cmp.Node) cmp.Node {
 
 func (r *QuoteRewriter) rewrite(n cmp.Node) ([]cmp.Node, cmp.Node) {
 	switch x := n.(type) {
-	case []cmp.Node:
-		return r.rewriteItems(x)
+	case *cmp.VectorNode:
+		ss, ms := r.rewriteItems(x.Items)
+		return ss, cmp.NewVectorAt(ms, x.Pos)
+	case *cmp.MapNode:
+		ss, ms := r.rewriteItems(x.Items)
+		return ss, cmp.NewMapAt(ms, x.Pos)
 	case *cmp.ListNode:
 		return r.rewriteList(x)
 	default:
@@ -36,7 +40,14 @@ func (r *QuoteRewriter) rewriteList(n *cmp.ListNode) ([]cmp.Node, cmp.Node) {
 	}
 	if cmp.IsCall(n, "quote") {
 		ss, m := r.rewrite(n.Items[1])
-		return r.empty(), cmp.Fn(ss, m)
+		fn := cmp.Fn(ss, m)
+		fn.Pos = n.Pos
+		return r.empty(), fn
+	}
+	if cmp.IsCall(n, "quasiquote") {
+		// A quasi-quoted form is data. Only its unquoted forms may contain
+		// quoted functions.
+		return r.empty(), RewriteUnquoted(r, n)
 	}
 	if cmp.IsCall(n, "unquote") {
 		switch y := n.Items[1].(type) {
@@ -51,7 +62,7 @@ func (r *QuoteRewriter) rewriteList(n *cmp.ListNode) ([]cmp.Node, cmp.Node) {
 		}
 	}
 	ss, ms := r.rewriteItems(n.Items)
-	return ss, cmp.NewList(ms)
+	return ss, cmp.NewListAt(ms, n.Pos)
 }
 
 func (r *QuoteRewriter) rewriteItems(ns []cmp.Node) ([]cmp.Node, []cmp.Node) {
@@ -80,9 +91,19 @@ func join(a []cmp.Node, b []cmp.Node) []cmp.Node {
 
 func contains(a []cmp.Node, x cmp.Node) bool {
 	for _, y := range a {
-		if reflect.DeepEqual(y, x) {
+		if sameNode(y, x) {
 			return true
 		}
 	}
 	return false
 }
+
+// sameNode compares two nodes regardless of their source positions.
+func sameNode(a cmp.Node, b cmp.Node) bool {
+	if as, ok := a.(*cmp.SymbolNode); ok {
+		if bs, ok := b.(*cmp.SymbolNode); ok {
+			return as.Name == bs.Name
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
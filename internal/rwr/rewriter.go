@@ -16,3 +16,29 @@ func RewriteItems(r Rewriter, ns []cmp.Node) []cmp.Node {
 	}
 	return ms
 }
+
+// RewriteUnquoted rewrites the unquoted forms of the quasi-quoted form n.
+// The remaining parts of n are data and are left untouched.
+func RewriteUnquoted(r Rewriter, n cmp.Node) cmp.Node {
+	switch x := n.(type) {
+	case *cmp.VectorNode:
+		return cmp.NewVectorAt(rewriteUnquotedItems(r, x.Items), x.Pos)
+	case *cmp.MapNode:
+		return cmp.NewMapAt(rewriteUnquotedItems(r, x.Items), x.Pos)
+	case *cmp.ListNode:
+		if cmp.IsCall(x, "unquote") && x.Len() == 2 {
+			return cmp.NewListAt([]cmp.Node{x.Items[0], r.Rewrite(x.Items[1])}, x.Pos)
+		}
+		return cmp.NewListAt(rewriteUnquotedItems(r, x.Items), x.Pos)
+	default:
+		return n
+	}
+}
+
+func rewriteUnquotedItems(r Rewriter, ns []cmp.Node) []cmp.Node {
+	ms := []cmp.Node{}
+	for _, n := range ns {
+		ms = append(ms, RewriteUnquoted(r, n))
+	}
+	return ms
+}
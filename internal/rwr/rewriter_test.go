@@ -1,6 +1,7 @@
 package rwr_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/mhoertnagl/noodles/internal/cmp"
@@ -61,6 +62,14 @@ func TestRewriteQuoteWithMultiOccurenceOfSingelVariable(t *testing.T) {
 	testRewriter(t, rw, `'(* ~n ~n ~n)`, `(fn [n] (* n n n))`)
 }
 
+func TestRewriteQuasiquote(t *testing.T) {
+	rw := rwr.NewQuoteRewriter()
+	testRewriter(t, rw,
+		"`(+ ~a ~(map '(inc ~x) b))",
+		"`(+ ~a ~(map (fn [x] (inc x)) b))",
+	)
+}
+
 func TestRewriteArgsSimple(t *testing.T) {
 	pars := []string{"a"}
 	args := []cmp.Node{parse("(+ 1 1)")}
@@ -119,10 +128,20 @@ func TestRewriteDefmacroSimple0(t *testing.T) {
 	testRewriter(t, rw, is, es)
 }
 
+func TestRewriteDefmacroQuasiquote(t *testing.T) {
+	is := `(do
+    (defmacro else [] true)
+    ` + "`(else ~else)" + `
+  )`
+	es := "(do `(else ~true))"
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
 func TestRewriteDefmacroSimple1(t *testing.T) {
 	is := `(do
     (defmacro defn [name args & body]
-      (def name (fn args (do @body))))
+      ` + "`" + `(def ~name (fn ~args (do ~@body))))
     (defn inc [x] (+ x 1) (- x 1))
   )`
 	es := `(do (def inc (fn [x] (do (+ x 1) (- x 1)))))`
@@ -130,10 +149,20 @@ func TestRewriteDefmacroSimple1(t *testing.T) {
 	testRewriter(t, rw, is, es)
 }
 
+func TestRewriteDefmacroMap(t *testing.T) {
+	is := `(do
+    (defmacro entry [k v] ` + "`" + `{~k [~v]})
+    (entry "a" (+ 1 2))
+  )`
+	es := `(do {"a" [(+ 1 2)]})`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
 func TestRewriteDefmacroSimple2(t *testing.T) {
 	is := `(do
     (defmacro defn [name args & body]
-      (def name (fn args (do @body))))
+      ` + "`" + `(def ~name (fn ~args (do ~@body))))
     (defn add [x y] (+ x y) (- x 1))
   )`
 	es := `(do (def add (fn [x y] (do (+ x y) (- x 1)))))`
@@ -143,8 +172,8 @@ func TestRewriteDefmacroSimple2(t *testing.T) {
 
 func TestRewriteDefmacroNested(t *testing.T) {
 	is := `(do
-    (defmacro m1 [a b] (m2 b a))
-    (defmacro m2 [a b] (- a b))
+    (defmacro m1 [a b] ` + "`" + `(m2 ~b ~a))
+    (defmacro m2 [a b] ` + "`" + `(- ~a ~b))
     (m1 1 2)
   )`
 	es := `(do (- 2 1))`
@@ -154,7 +183,7 @@ func TestRewriteDefmacroNested(t *testing.T) {
 
 func TestRewriteDefmacroNameClash(t *testing.T) {
 	is := `(do
-    (defmacro m1 [& x] (+ @x 1))
+    (defmacro m1 [& x] ` + "`" + `(+ ~@x 1))
     (m1 x y)
   )`
 	es := `(do (+ x y 1))`
@@ -165,7 +194,7 @@ func TestRewriteDefmacroNameClash(t *testing.T) {
 func TestRewriteDefmacroVarArg1(t *testing.T) {
 	is := `(do
     (defmacro defn [name args & body]
-      (def name (fn args (do @body))))
+      ` + "`" + `(def ~name (fn ~args (do ~@body))))
     (defn vec [& args] args)
   )`
 	es := `(do (def vec (fn [& args] (do args))))`
@@ -175,7 +204,7 @@ func TestRewriteDefmacroVarArg1(t *testing.T) {
 
 func TestRewriteDefmacroVarArg2(t *testing.T) {
 	is := `(do
-    (defmacro m1 [a & b] (:: a b))
+    (defmacro m1 [a & b] ` + "`" + `(:: ~a ~b))
     (m1 1 2 3 4)
   )`
 	es := `(do (:: 1 [2 3 4]))`
@@ -185,7 +214,7 @@ func TestRewriteDefmacroVarArg2(t *testing.T) {
 
 func TestRewriteDefmacroPrint1(t *testing.T) {
 	is := `(do
-    (defmacro print [& args] (write *STD-OUT* @args))
+    (defmacro print [& args] ` + "`" + `(write *STD-OUT* ~@args))
     (print "Hello" ", " "World" "!")
   )`
 	es := `(do (write *STD-OUT* "Hello" ", " "World" "!"))`
@@ -195,8 +224,8 @@ func TestRewriteDefmacroPrint1(t *testing.T) {
 
 func TestRewriteDefmacroPrint2(t *testing.T) {
 	is := `(do
-    (defmacro print [& args] (write *STD-OUT* @args))
-    (defmacro println [& args] (print @args "\n"))
+    (defmacro print [& args] ` + "`" + `(write *STD-OUT* ~@args))
+    (defmacro println [& args] ` + "`" + `(print ~@args "\n"))
     (println "Hello, World!")
   )`
 	es := `(do (write *STD-OUT* "Hello, World!" "\n"))`
@@ -204,6 +233,193 @@ func TestRewriteDefmacroPrint2(t *testing.T) {
 	testRewriter(t, rw, is, es)
 }
 
+func TestRewriteDefmacroProcedural(t *testing.T) {
+	is := `(do
+    (defmacro sum [& xs] (+ @xs))
+    (defmacro swap [xs] [(nth 1 xs) (nth 0 xs)])
+    (sum 1 2 3)
+    (swap [1 (+ 1 1)])
+  )`
+	es := `(do 6 [(+ 1 1) 1])`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteDefmacroCallsDefinitions(t *testing.T) {
+	is := `(do
+    (def n 2)
+    (do (def twice (fn [x] (* n x))))
+    (defmacro four [x] (twice (twice x)))
+    (four 3)
+  )`
+	es := `(do (def n 2) (do (def twice (fn [x] (* n x)))) 12)`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteDefmacroSkipsDefinitions(t *testing.T) {
+	r := cmp.NewReader()
+	r.LoadFile("test.splis", `(do
+    (def x (write *STD-OUT* "side effect"))
+    (let (y 1) (def z (fn [] y)))
+    (defmacro m [] (z))
+    (m)
+  )`)
+	p := cmp.NewParser()
+	rw := rwr.NewMacroRewriter()
+	rw.Rewrite(p.Parse(r))
+	testErrors(t, rw.Errors(), "test.splis:4:21: unknown symbol [z]")
+}
+
+func TestRewriteDefmacroLetStar(t *testing.T) {
+	is := `(do
+    (defmacro let* [bs body]
+      (if (= (len bs) 0)
+        body
+        ` + "`" + `((fn [~(nth 0 bs)] (let* ~(drop 2 bs) ~body)) ~(nth 1 bs))))
+    (let* [a 1 b a] (+ a b))
+  )`
+	es := `(do ((fn [a] ((fn [b] (+ a b)) a)) 1))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteMacroexpand(t *testing.T) {
+	is := `(do
+    (defmacro m1 [a b] ` + "`" + `(m2 ~b ~a))
+    (defmacro m2 [a b] ` + "`" + `(- ~a ~b))
+    (macroexpand-1 (m1 1 2))
+    (macroexpand (m1 1 2))
+    (macroexpand (+ 1 2))
+  )`
+	es := "(do (quasiquote (m2 2 1)) (quasiquote (- 2 1)) (quasiquote (+ 1 2)))"
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteExpand1(t *testing.T) {
+	rw := rwr.NewMacroRewriter()
+	rw.Rewrite(parse(`(defmacro m1 [a b] ` + "`" + `(m2 ~b ~a))`))
+	rw.Rewrite(parse(`(defmacro m2 [a b] ` + "`" + `(- ~a ~b))`))
+	if a := cmp.PrintAst(rw.Expand1(parse(`(m1 1 2)`))); a != "(m2 2 1)" {
+		t.Errorf("Expecting [(m2 2 1)] but got [%s]", a)
+	}
+	if a := cmp.PrintAst(rw.Expand1(parse(`(+ 1 2)`))); a != "(+ 1 2)" {
+		t.Errorf("Expecting [(+ 1 2)] but got [%s]", a)
+	}
+}
+
+func TestRewriteHygieneSet(t *testing.T) {
+	is := `(do
+    (defmacro measure [f] ` + "`" + `(do (set start (runtime)) ~f (- (runtime) start)))
+    (measure (set start 1))
+  )`
+	es := `(do (do (set start#1 (runtime)) (set start 1) (- (runtime) start#1)))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneLet(t *testing.T) {
+	is := `(do
+    (defmacro or2 [a b] ` + "`" + `(let (t ~a) (if t t ~b)))
+    (let (t true) (or2 false t))
+  )`
+	es := `(do (let (t true) (let (t#1 false) (if t#1 t#1 t))))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneFn(t *testing.T) {
+	is := `(do
+    (defmacro adder [x] ` + "`" + `(fn [y & ys] (+ y ~x @ys)))
+    (adder y)
+  )`
+	es := `(do (fn [y#1 & ys#2] (+ y#1 y (dissolve ys#2))))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneCatch(t *testing.T) {
+	is := `(do
+    (defmacro safe [e] ` + "`" + `(try (/ 1 0) (catch err ~e)))
+    (safe err)
+  )`
+	es := `(do (try (/ 1 0) (catch err#1 err)))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneScope(t *testing.T) {
+	is := `(do
+    (defmacro m [a] ` + "`" + `(do (let (x (+ x 1)) x) x ~a))
+    (m x)
+  )`
+	es := `(do (do (let (x#1 (+ x 1)) x#1) x x))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneLetrec(t *testing.T) {
+	is := `(do
+    (defmacro ev [n e] ` + "`" + `(letrec (ev? (fn [k] (if (= k 0) true (od? (- k 1))))
+                                    od? (fn [k] (if (= k 0) false (ev? (- k 1)))))
+                             (if (ev? ~n) ~e od?)))
+    (ev 2 od?)
+  )`
+	es := `(do
+    (letrec (ev?#1 (fn [k#3] (if (= k#3 0) true (od?#2 (- k#3 1))))
+             od?#2 (fn [k#4] (if (= k#4 0) false (ev?#1 (- k#4 1)))))
+      (if (ev?#1 2) od? od?#2))
+  )`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneLoop(t *testing.T) {
+	is := `(do
+    (defmacro times [n e] ` + "`" + `(loop (i 0 acc 0) (if (< i ~n) (recur (+ i 1) (+ acc ~e)) acc)))
+    (times 2 i)
+    (defmacro times2 [n e] ` + "`" + `(loop [i 0] (if (< i ~n) (recur (+ i ~e)) i)))
+    (times2 i 1)
+  )`
+	es := `(do
+    (loop (i#1 0 acc#2 0) (if (< i#1 2) (recur (+ i#1 1) (+ acc#2 i)) acc#2))
+    (loop [i#3 0] (if (< i#3 i) (recur (+ i#3 1)) i#3))
+  )`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneFnClauses(t *testing.T) {
+	is := `(do
+    (defmacro f [e] ` + "`" + `(fn ([x] (+ x ~e)) ([x (y x) & zs] (+ x y ~e))))
+    (f y)
+  )`
+	es := `(do (fn ([x#1] (+ x#1 y)) ([x#2 (y#3 x#2) & zs#4] (+ x#2 y#3 y))))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteHygieneArgsNotRenamed(t *testing.T) {
+	is := `(do
+    (defmacro defn [name args & body] ` + "`" + `(def ~name (fn ~args (do ~@body))))
+    (defn f [x] x)
+  )`
+	es := `(do (def f (fn [x] (do x))))`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
+func TestRewriteGensym(t *testing.T) {
+	is := `(do
+    (defmacro m [] (let (s (gensym "s")) ` + "`" + `[~s ~s ~(gensym)]))
+    (m)
+  )`
+	es := `(do [s#1 s#1 G#2])`
+	rw := rwr.NewMacroRewriter()
+	testRewriter(t, rw, is, es)
+}
+
 func TestRewriteUse(t *testing.T) {
 	paths := []string{util.SplisHomePath()}
 	is := `(do
@@ -221,6 +437,91 @@ func TestRewriteUse(t *testing.T) {
 	testRewriter(t, rw, is, es)
 }
 
+func TestRewriteUseMissingModule(t *testing.T) {
+	rw := rwr.NewUseRewriter([]string{})
+	r := cmp.NewReader()
+	p := cmp.NewParser()
+	r.LoadFile("test.splis", "(do\n  (use \"missing\"))")
+	rw.Rewrite(p.Parse(r))
+	testErrors(t, rw.Errors(), "test.splis:2:3: Could not find module [missing] in [].")
+}
+
+func TestRewriteMacroPosition(t *testing.T) {
+	is := `(do
+    (defmacro inc [x] ` + "`" + `(+ ~x 1))
+    (inc y))`
+	rw := rwr.NewMacroRewriter()
+	r := cmp.NewReader()
+	p := cmp.NewParser()
+	r.LoadFile("test.splis", is)
+	n := rw.Rewrite(p.Parse(r)).(*cmp.ListNode)
+	// The expanded body takes the position of the macro invocation while the
+	// arguments keep their own positions.
+	e := n.Items[1].(*cmp.ListNode)
+	if a := e.Pos.String(); a != "test.splis:3:5" {
+		t.Errorf("Expecting [test.splis:3:5] but got [%s]", a)
+	}
+	if a := cmp.PosOf(e.Items[1]).String(); a != "test.splis:3:10" {
+		t.Errorf("Expecting [test.splis:3:10] but got [%s]", a)
+	}
+}
+
+func TestRewriteMacroArityError(t *testing.T) {
+	is := `(do
+    (defmacro inc [x] ` + "`" + `(+ ~x 1))
+    (inc))`
+	rw := rwr.NewMacroRewriter()
+	r := cmp.NewReader()
+	p := cmp.NewParser()
+	r.LoadFile("test.splis", is)
+	rw.Rewrite(p.Parse(r))
+	testErrors(t, rw.Errors(), "test.splis:3:5: [inc] expects [1] arguments but got [0]")
+}
+
+func TestRewriteMacroRuntimeError(t *testing.T) {
+	is := `(do
+    (defmacro bad [x] (nth 1 x))
+    (defmacro fun [] (fn [] 1))
+    (bad [1])
+    (fun))`
+	rw := rwr.NewMacroRewriter()
+	r := cmp.NewReader()
+	p := cmp.NewParser()
+	r.LoadFile("test.splis", is)
+	rw.Rewrite(p.Parse(r))
+	testErrors(t, rw.Errors(),
+		"test.splis:4:5: [bad] runtime error at [85] in [Nth]: index [1] out of bounds [1] (operands: [1] [[1]])",
+		"test.splis:5:5: [fun] cannot convert [fn] into code",
+	)
+}
+
+func TestToValToNode(t *testing.T) {
+	n := parse(`(f :a [x nil] {"k" 1.5} true)`)
+	v := rwr.ToVal(n)
+	if s := fmt.Sprint(v); s != "(f :a [x nil] {k 1.5} true)" {
+		t.Errorf("Unexpected value [%s]", s)
+	}
+	m, err := rwr.ToNode(v, cmp.Pos{})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if equalNode(m, n) == false {
+		t.Errorf("Expecting [%s] but got [%s]", cmp.PrintAst(n), cmp.PrintAst(m))
+	}
+}
+
+func testErrors(t *testing.T, act []string, exp ...string) {
+	t.Helper()
+	if len(act) != len(exp) {
+		t.Fatalf("Expecting %v but got %v", exp, act)
+	}
+	for i := range exp {
+		if act[i] != exp[i] {
+			t.Errorf("Expecting [%s] but got [%s]", exp[i], act[i])
+		}
+	}
+}
+
 // TODO: Move to rewriter_test
 //
 // func TestCompileSimpleQuote(t *testing.T) {
@@ -328,6 +629,8 @@ func parse(i string) cmp.Node {
 }
 
 func equalNode(l cmp.Node, r cmp.Node) bool {
+	l = cmp.Lit(l)
+	r = cmp.Lit(r)
 	switch lx := l.(type) {
 	case bool:
 		if rx, ok := r.(bool); ok {
@@ -337,17 +640,27 @@ func equalNode(l cmp.Node, r cmp.Node) bool {
 		if rx, ok := r.(int64); ok {
 			return lx == rx
 		}
+	case float64:
+		if rx, ok := r.(float64); ok {
+			return lx == rx
+		}
 	case string:
 		if rx, ok := r.(string); ok {
 			return lx == rx
 		}
+	case cmp.Keyword, cmp.Nil:
+		return l == r
 	case *cmp.SymbolNode:
 		if rx, ok := r.(*cmp.SymbolNode); ok {
 			return lx.Name == rx.Name
 		}
-	case []cmp.Node:
-		if rx, ok := r.([]cmp.Node); ok {
-			return equalList(lx, rx)
+	case *cmp.VectorNode:
+		if rx, ok := r.(*cmp.VectorNode); ok {
+			return equalList(lx.Items, rx.Items)
+		}
+	case *cmp.MapNode:
+		if rx, ok := r.(*cmp.MapNode); ok {
+			return equalList(lx.Items, rx.Items)
 		}
 	case *cmp.ListNode:
 		if rx, ok := r.(*cmp.ListNode); ok {
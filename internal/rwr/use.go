@@ -13,18 +13,23 @@ type usingsSet map[string]bool
 type UseRewriter struct {
 	paths  []string
 	usings usingsSet
+	files  []string
 	rdr    *cmp.Reader
 	prs    *cmp.Parser
 	err    []string
+	// mods are the names of the used modules in the order of their use.
+	mods []string
 }
 
 func NewUseRewriter(paths []string) *UseRewriter {
 	return &UseRewriter{
 		paths:  paths,
 		usings: usingsSet{},
+		files:  make([]string, 0),
 		rdr:    cmp.NewReader(),
 		prs:    cmp.NewParser(),
 		err:    make([]string, 0),
+		mods:   make([]string, 0),
 	}
 }
 
@@ -32,15 +37,45 @@ func (r *UseRewriter) Errors() []string {
 	return r.err
 }
 
-func (r *UseRewriter) error(format string, args ...interface{}) {
-	e := fmt.Sprintf(format, args...)
+// Files returns the paths of all module files that have been loaded so far in
+// the order they have been loaded.
+func (r *UseRewriter) Files() []string {
+	return r.files
+}
+
+// UseSnapshot is the state of a UseRewriter at some point.
+type UseSnapshot struct {
+	mods  int
+	files int
+}
+
+// Snapshot returns the current state of the rewriter.
+func (r *UseRewriter) Snapshot() UseSnapshot {
+	return UseSnapshot{mods: len(r.mods), files: len(r.files)}
+}
+
+// Restore forgets the modules that have been used since the snapshot s was
+// taken. It rolls back the modules of a failed compilation. Using them again
+// loads them again.
+func (r *UseRewriter) Restore(s UseSnapshot) {
+	for _, mod := range r.mods[s.mods:] {
+		delete(r.usings, mod)
+	}
+	r.mods = r.mods[:s.mods]
+	r.files = r.files[:s.files]
+}
+
+func (r *UseRewriter) error(pos cmp.Pos, format string, args ...interface{}) {
+	e := cmp.FormatError(pos, fmt.Sprintf(format, args...))
 	r.err = append(r.err, e)
 }
 
 func (r *UseRewriter) Rewrite(n cmp.Node) cmp.Node {
 	switch x := n.(type) {
-	case []cmp.Node:
-		return RewriteItems(r, x)
+	case *cmp.VectorNode:
+		return cmp.NewVectorAt(RewriteItems(r, x.Items), x.Pos)
+	case *cmp.MapNode:
+		return cmp.NewMapAt(RewriteItems(r, x.Items), x.Pos)
 	case *cmp.ListNode:
 		return r.rewriteList(x)
 	default:
@@ -52,34 +87,47 @@ func (r *UseRewriter) rewriteList(n *cmp.ListNode) cmp.Node {
 	if len(n.Items) == 0 {
 		return n
 	}
-	// TODO: Length of items should be 2 (use "...")
 	if cmp.IsCall(n, "use") {
-		if mod, ok := n.Items[1].(string); ok {
+		if len(n.Items) != 2 {
+			r.error(n.Pos, "[use] requires exactly one argument")
+			return nil
+		}
+		if mod, ok := cmp.Lit(n.Items[1]).(string); ok {
 			if r.usings[mod] {
 				// File has already been included. Skip.
 				return nil
 			}
 			r.usings[mod] = true
-			return r.loadUse(mod)
+			r.mods = append(r.mods, mod)
+			return r.loadUse(mod, n.Pos)
 		}
 	}
-	return cmp.NewList(RewriteItems(r, n.Items))
+	return cmp.NewListAt(RewriteItems(r, n.Items), n.Pos)
 }
 
-func (r *UseRewriter) loadUse(mod string) cmp.Node {
-	s := r.loadModule(r.paths, mod)
-	r.rdr.Load(s)
+func (r *UseRewriter) loadUse(mod string, pos cmp.Pos) cmp.Node {
+	file, s, ok := r.loadModule(r.paths, mod)
+	if !ok {
+		r.error(pos, "Could not find module [%s] in %v.", mod, r.paths)
+		return nil
+	}
+	r.files = append(r.files, file)
+	// Positions in the module refer to the module file.
+	r.rdr.LoadFile(file, s)
 	c := r.prs.Parse(r.rdr)
+	for _, e := range r.prs.Errors() {
+		r.err = append(r.err, e.Error())
+	}
 	return r.Rewrite(c)
 }
 
-func (r *UseRewriter) loadModule(dirs []string, mod string) string {
+func (r *UseRewriter) loadModule(dirs []string, mod string) (string, string, bool) {
 	for _, dir := range dirs {
-		modBytes, err := ioutil.ReadFile(path.Join(dir, mod+".splis"))
+		file := path.Join(dir, mod+".splis")
+		modBytes, err := ioutil.ReadFile(file)
 		if err == nil {
-			return string(modBytes)
+			return file, string(modBytes), true
 		}
 	}
-	r.error("Could not find module [%s] in %v.", mod, dirs)
-	return ""
+	return "", "", false
 }
@@ -0,0 +1,268 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/mhoertnagl/noodles/internal/asm"
+	"github.com/mhoertnagl/noodles/internal/cmp"
+	"github.com/mhoertnagl/noodles/internal/rwr"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// Session compiles and executes source code incrementally on a single virtual
+// machine. Global definitions, macros and used modules persist between
+// evaluations.
+type Session struct {
+	rdr *cmp.Reader
+	prs *cmp.Parser
+	urw *rwr.UseRewriter
+	qrw *rwr.QuoteRewriter
+	mrw *rwr.MacroRewriter
+	cmp *cmp.Compiler
+	asm *asm.Assembler
+	vm  *vm.VM
+	dbg *vm.DebugInfo
+}
+
+// New creates a new session. Used modules are searched for in dirs.
+func New(dirs []string) *Session {
+	s := &Session{
+		rdr: cmp.NewReader(),
+		prs: cmp.NewParser(),
+		urw: rwr.NewUseRewriter(dirs),
+		qrw: rwr.NewQuoteRewriter(),
+		mrw: rwr.NewMacroRewriter(),
+		cmp: cmp.NewCompiler(),
+		asm: asm.NewAssembler(),
+		vm:  vm.NewVM(1024, 0, 512),
+		dbg: vm.NewDebugInfo(),
+	}
+	s.cmp.AddDefaultGlobals()
+	s.cmp.EnableDebugInfo()
+	s.vm.AddDefaultGlobals()
+	s.vm.SetDebugInfo(s.dbg)
+	return s
+}
+
+// SetArgs provides the command line arguments to the program in *ARGS*.
+func (s *Session) SetArgs(args []string) {
+	s.vm.SetArgs(args)
+}
+
+// SetLimits sets the maximum sizes of the stack and the frames stack of the
+// virtual machine.
+func (s *Session) SetLimits(stackLimit int64, frameLimit int64) {
+	s.vm.SetLimits(stackLimit, frameLimit)
+}
+
+// Register binds a native function to its name in the global definitions of
+// both the compiler and the virtual machine.
+func (s *Session) Register(n *vm.Native) {
+	id := s.cmp.AddGlobal(n.Name)
+	s.vm.AddGlobal(id, n)
+}
+
+// Program is a compiled program along with its assembly, its debug
+// information, the names of its global definitions indexed by their IDs and
+// the module files it uses.
+type Program struct {
+	Asm     asm.AsmCode
+	Code    vm.Ins
+	Debug   *vm.DebugInfo
+	Globals []string
+	Modules []string
+}
+
+// Compile compiles the source of a whole program. The file name is used in
+// diagnostics only. Used modules are searched for in dirs. The program may
+// call the native functions. They have to be bound with Link before the
+// program is run.
+func Compile(dirs []string, file string, src string, natives ...*vm.Native) (*Program, error) {
+	s := New(dirs)
+	for _, n := range natives {
+		s.Register(n)
+	}
+	a, code, err := s.compile(file, src)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{
+		Asm:     a,
+		Code:    code,
+		Debug:   s.asm.DebugInfo(),
+		Globals: s.cmp.Globals(),
+		Modules: s.urw.Files(),
+	}, nil
+}
+
+// Link binds the native functions to the global IDs the program has assigned
+// to their names. Natives the program does not know are ignored.
+func (p *Program) Link(m *vm.VM, natives ...*vm.Native) {
+	for _, n := range natives {
+		for id, name := range p.Globals {
+			if name == n.Name {
+				m.AddGlobal(uint64(id), n)
+			}
+		}
+	}
+}
+
+// Error collects the diagnostics of the parser, the rewriters and the
+// compiler.
+type Error struct {
+	Msgs []string
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Msgs, "\n")
+}
+
+// Eval compiles and runs all forms of src. The file name is used in
+// diagnostics only. Eval returns the value of the last form if there is one.
+func (s *Session) Eval(file string, src string) (vm.Val, bool, error) {
+	_, code, err := s.compile(file, src)
+	if err != nil {
+		return nil, false, err
+	}
+	// Drop any leftovers of a previous evaluation that may have failed.
+	s.vm.Reset()
+	if err := s.vm.Append(code); err != nil {
+		return nil, false, err
+	}
+	if s.vm.StackSize() > 0 {
+		return s.vm.InspectStack(0), true, nil
+	}
+	return nil, false, nil
+}
+
+// Parse parses all forms of src without rewriting them. The file name is
+// used in diagnostics only. Multiple forms are wrapped in a do form. Parse
+// returns nil if there are no forms.
+func Parse(file string, src string) (cmp.Node, error) {
+	return New([]string{}).parse(file, src)
+}
+
+// Expand parses all forms of src, includes the used modules and expands all
+// quotes and macros. Used modules are searched for in dirs.
+func Expand(dirs []string, file string, src string) (cmp.Node, error) {
+	return New(dirs).Expand(file, src)
+}
+
+// Expand parses all forms of src, includes the used modules and expands all
+// quotes and macros. Macros defined by previous evaluations are expanded as
+// well. Expand returns nil if nothing is left after the expansion.
+func (s *Session) Expand(file string, src string) (cmp.Node, error) {
+	n, err := s.parse(file, src)
+	if n == nil || err != nil {
+		return nil, err
+	}
+
+	// The rewriters accumulate their errors. Only report the errors of this
+	// expansion.
+	nu := len(s.urw.Errors())
+	nm := len(s.mrw.Errors())
+
+	n = s.urw.Rewrite(n)
+	if errs := s.urw.Errors()[nu:]; len(errs) > 0 {
+		return nil, &Error{Msgs: errs}
+	}
+
+	n = s.qrw.Rewrite(n)
+
+	n = s.mrw.Rewrite(n)
+	if errs := s.mrw.Errors()[nm:]; len(errs) > 0 {
+		return nil, &Error{Msgs: errs}
+	}
+	return n, nil
+}
+
+func (s *Session) parse(file string, src string) (cmp.Node, error) {
+	s.rdr.LoadFile(file, src)
+	ns := s.prs.ParseAll(s.rdr)
+	if len(s.prs.Errors()) > 0 {
+		msgs := []string{}
+		for _, e := range s.prs.Errors() {
+			msgs = append(msgs, e.Error())
+		}
+		return nil, &Error{Msgs: msgs}
+	}
+
+	switch len(ns) {
+	case 0:
+		return nil, nil
+	case 1:
+		return ns[0], nil
+	default:
+		return cmp.CallVar("do", ns...), nil
+	}
+}
+
+func (s *Session) compile(file string, src string) (asm.AsmCode, vm.Ins, error) {
+	// A failed compilation leaves no traces. The modules it has used can be
+	// used again and the macros it has defined vanish.
+	us := s.urw.Snapshot()
+	ms := s.mrw.Snapshot()
+	n, err := s.Expand(file, src)
+	if err != nil {
+		s.urw.Restore(us)
+		s.mrw.Restore(ms)
+		return nil, nil, err
+	}
+
+	// Macro definitions and modules that have been used before vanish.
+	if n == nil {
+		return asm.AsmCode{}, vm.Ins{}, nil
+	}
+
+	// The compiler accumulates its errors. Only report the errors of this
+	// compilation. The globals it has registered are dropped again because
+	// the code that defines them never runs.
+	nc := len(s.cmp.Errors())
+	ng := s.cmp.NumGlobals()
+	a := s.cmp.Compile(n)
+	if errs := s.cmp.Errors()[nc:]; len(errs) > 0 {
+		s.cmp.TruncateGlobals(ng)
+		s.urw.Restore(us)
+		s.mrw.Restore(ms)
+		return nil, nil, &Error{Msgs: errs}
+	}
+
+	s.vm.ReserveGlobals(s.cmp.NumGlobals())
+
+	// The code will be appended to the code of previous evaluations.
+	code := s.asm.AssembleAt(a, uint64(s.vm.CodeSize()))
+	s.dbg.Merge(s.asm.DebugInfo())
+	return a, code, nil
+}
+
+// Complete reports whether all lists, vectors, hash maps and strings in src
+// are closed. Incomplete input needs more lines before it can be evaluated.
+func Complete(src string) bool {
+	rdr := cmp.NewReader()
+	rdr.Load(src)
+	depth := 0
+	for tok := rdr.Next(); tok != ""; tok = rdr.Next() {
+		switch {
+		case tok == "(" || tok == "[" || tok == "{":
+			depth++
+		case tok == ")" || tok == "]" || tok == "}":
+			depth--
+		case isOpenString(tok):
+			return false
+		}
+	}
+	return depth <= 0
+}
+
+func isOpenString(tok string) bool {
+	if !strings.HasPrefix(tok, `"`) {
+		return false
+	}
+	// Count the backslashes that precede the final quote. An odd number means
+	// that the quote is escaped.
+	bs := 0
+	for i := len(tok) - 2; i > 0 && tok[i] == '\\'; i-- {
+		bs++
+	}
+	return len(tok) == 1 || !strings.HasSuffix(tok, `"`) || bs%2 == 1
+}
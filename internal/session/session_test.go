@@ -0,0 +1,518 @@
+package session_test
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mhoertnagl/noodles/internal/cmp"
+	"github.com/mhoertnagl/noodles/internal/session"
+	"github.com/mhoertnagl/noodles/internal/util"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+func TestEvalExpression(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(+ 1 2)`, int64(3))
+}
+
+func TestEvalMultipleForms(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `1 2 3`, int64(3))
+}
+
+func TestEvalGlobalsPersist(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def x 41)`, nil)
+	testEval(t, s, `(+ x 1)`, int64(42))
+}
+
+func TestEvalFunctionsPersist(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def add (fn [a b] (+ a b)))`, nil)
+	testEval(t, s, `(def inc (fn [a] (add a 1)))`, nil)
+	testEval(t, s, `(inc 41)`, int64(42))
+}
+
+func TestEvalClosuresPersist(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def adder (fn [a] (fn [b] (+ a b))))`, nil)
+	testEval(t, s, `(def add2 (adder 2))`, nil)
+	testEval(t, s, `(add2 40)`, int64(42))
+}
+
+func TestEvalMacrosPersist(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(defmacro twice [x] (+ x x))`, nil)
+	testEval(t, s, `(twice 21)`, int64(42))
+	testEval(t, s, "(defmacro unless [c x] `(if ~c nil ~x))", nil)
+	testEval(t, s, `(let (x 1) (unless (= x 2) (twice 2)))`, int64(4))
+}
+
+func TestEvalHygienicMacros(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, "(defmacro double [x] `(let (v ~x) (+ v v)))", nil)
+	testEval(t, s, `(let (v 5) (double (+ v 1)))`, int64(12))
+}
+
+func TestEvalRecoversFromErrors(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def x 1)`, nil)
+	if _, _, err := s.Eval("", `(+ x y)`); err == nil {
+		t.Errorf("Expecting a compile error")
+	}
+	if _, _, err := s.Eval("", `(nth 5 [1 2])`); err == nil {
+		t.Errorf("Expecting a runtime error")
+	}
+	testEval(t, s, `(+ x 41)`, int64(42))
+}
+
+func TestEvalRollsBackFailedDefinitions(t *testing.T) {
+	s := session.New([]string{})
+	if _, _, err := s.Eval("", `(def x (foo))`); err == nil {
+		t.Errorf("Expecting a compile error")
+	}
+	if _, _, err := s.Eval("", `(+ 1 x 2)`); err == nil || !strings.Contains(err.Error(), "unknown symbol [x]") {
+		t.Errorf("Expecting [x] to be unknown but got [%v]", err)
+	}
+	testEval(t, s, `(def x 5)`, nil)
+	testEval(t, s, `(+ 1 x 2)`, int64(8))
+	// The definition compiles but its value fails at runtime.
+	if _, _, err := s.Eval("", `(def y (/ 1 0))`); err == nil {
+		t.Errorf("Expecting a runtime error")
+	}
+	if _, _, err := s.Eval("", `(+ 1 y 2)`); err == nil || !strings.Contains(err.Error(), "undefined global") {
+		t.Errorf("Expecting [y] to be undefined but got [%v]", err)
+	}
+}
+
+func TestEvalRollsBackFailedUses(t *testing.T) {
+	s := session.New([]string{util.SplisHomePath()})
+	if _, _, err := s.Eval("", `(do (use "test/prelude") (defmacro m [] 1) (foo))`); err == nil {
+		t.Errorf("Expecting a compile error")
+	}
+	if _, _, err := s.Eval("", `(m)`); err == nil || !strings.Contains(err.Error(), "unknown symbol [m]") {
+		t.Errorf("Expecting [m] to be unknown but got [%v]", err)
+	}
+	testEval(t, s, `(use "test/prelude")`, nil)
+	testEval(t, s, `(inc 41)`, int64(42))
+}
+
+func TestEvalTailCalls(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def odd? false)`, nil)
+	testEval(t, s, `(def even? (fn [n] (if (= n 0) true (odd? (- n 1)))))`, nil)
+	testEval(t, s, `(def odd? (fn [n] (cond (= n 0) false true (let (m (- n 1)) (even? m)))))`, nil)
+	testEval(t, s, `(even? 100000)`, true)
+	testEval(t, s, `(def down (fn [n] (and (> n 0) ((fn [m] (down m)) (- n 1)))))`, nil)
+	testEval(t, s, `(down 100000)`, false)
+}
+
+func TestEvalDeepRecursion(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def sum (fn [n] (if (= n 0) 0 (+ n (sum (- n 1))))))`, nil)
+	testEval(t, s, `(sum 10000)`, int64(50005000))
+}
+
+func TestEvalStackOverflow(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def f (fn [n] (+ 1 (f n))))`, nil)
+	_, _, err := s.Eval("", `(f 1)`)
+	if err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("Expecting a stack overflow but got [%v]", err)
+	}
+	testEval(t, s, `(+ 1 2)`, int64(3))
+}
+
+func TestEvalMaps(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def m {"a" 1 true [2] 3 "c"})`, nil)
+	testEval(t, s, `(get m "a")`, int64(1))
+	testEval(t, s, `(get m 3.0)`, "c")
+	testEval(t, s, `(get m "z" 0)`, int64(0))
+	testEval(t, s, `(contains? m "z")`, false)
+	testEval(t, s, `(count (assoc m "z" 26 "y" 25))`, int64(5))
+	testEval(t, s, `(keys (dissoc m "a" 3))`, []vm.Val{true})
+	testEval(t, s, `(vals (assoc {} [1] {}))`, []vm.Val{vm.NewMap()})
+	testEval(t, s, `(= (assoc m "a" 1) m)`, true)
+	testEval(t, s, `(= (assoc m "a" 2) m)`, false)
+	testEval(t, s, `(((fn [x] (fn [] {x x})) 1))`, vm.NewMap().Assoc(int64(1), int64(1)))
+}
+
+func TestEvalKeywords(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def m {:a 1 :b 2})`, nil)
+	testEval(t, s, `(get m :a)`, int64(1))
+	testEval(t, s, `(contains? m :c)`, false)
+	testEval(t, s, `(= :a :a)`, true)
+	testEval(t, s, `(= :a "a")`, false)
+	testEval(t, s, `(:mod 7 3)`, int64(1))
+	testEval(t, s, `(+ (:@ [1 2 3]))`, int64(6))
+	testEval(t, s, `(keyword? :len)`, true)
+	testEval(t, s, `(= :len :len)`, true)
+	testEval(t, s, `(type-of :+)`, vm.Intern("keyword"))
+	testEval(t, s, `(get {:len 3} :len)`, int64(3))
+	testEval(t, s, `(keys m)`, []vm.Val{vm.Intern("a"), vm.Intern("b")})
+}
+
+func TestEvalPrimitiveValues(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def apply2 (fn [f x y] (f x y)))`, nil)
+	testEval(t, s, `(apply2 + 1 2)`, int64(3))
+	testEval(t, s, `(apply2 - 5 2)`, int64(3))
+	testEval(t, s, `(apply2 < 1 2)`, true)
+	testEval(t, s, `((if true * /) 2 3)`, int64(6))
+}
+
+func TestEvalNil(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `nil`, vm.Nil{})
+	testEval(t, s, `[1 nil 2]`, []vm.Val{int64(1), vm.Nil{}, int64(2)})
+	testEval(t, s, `(+ 1 (if (= nil nil) 2 3))`, int64(3))
+	testEval(t, s, `(get {nil 1} nil)`, int64(1))
+	testEval(t, s, `(get {:a nil} :a 0)`, vm.Nil{})
+}
+
+func TestEvalTypePredicates(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(bool? false)`, true)
+	testEval(t, s, `(vec? [1])`, true)
+	testEval(t, s, `(vec? {})`, false)
+	testEval(t, s, `(fn? (fn [x] x))`, true)
+	testEval(t, s, `(fn? +)`, true)
+	testEval(t, s, `(keyword? :a)`, true)
+	testEval(t, s, `(type-of "a")`, vm.Intern("string"))
+	testEval(t, s, `(type-of nil)`, vm.Intern("nil"))
+	testEval(t, s, `(type-of {})`, vm.Intern("map"))
+}
+
+func TestEvalTryCatch(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(try 1 (catch e 2))`, int64(1))
+	testEval(t, s, `(try (throw :oops) (catch e e))`, vm.Intern("oops"))
+	testEval(t, s, `(def f (fn [n] (if (= n 0) (throw "bottom") (+ 1 (f (- n 1))))))`, nil)
+	testEval(t, s, `(let (x 1) (+ x (try (f 10) (catch e (+ x 1)))))`, int64(3))
+	testEval(t, s, `(try (f 3) (catch e e))`, "bottom")
+	testEval(t, s, `(get (try (/ 1 0) (catch e e)) :op)`, "Div")
+	testEval(t, s, `(get (try (nth 5 [1]) (catch e e)) :op)`, "Nth")
+	testEval(t, s, `(try (+ 1 "a") (catch e (map? e)))`, true)
+	testEval(t, s, `(try (try (throw 1) (catch e (throw (+ e 1)))) (catch e e))`, int64(2))
+	testEval(t, s, `(+ 1 (try (throw 1) (catch e 41)))`, int64(42))
+	if _, _, err := s.Eval("", `(throw 1)`); err == nil {
+		t.Errorf("Expecting an uncaught exception")
+	}
+}
+
+func TestEvalQuasiquote(t *testing.T) {
+	s := session.New([]string{})
+	sym := vm.NewSymbol
+	testEval(t, s, "`a", sym("a"))
+	testEval(t, s, "`(+ 1 2)", vm.NewList([]vm.Val{sym("+"), int64(1), int64(2)}))
+	testEval(t, s, "(let (x 1 ys [2 3]) `(f ~x ~@ys [~x]))",
+		vm.NewList([]vm.Val{sym("f"), int64(1), int64(2), int64(3), []vm.Val{int64(1)}}))
+	testEval(t, s, "`{:a ~(+ 1 1)}", vm.NewMap().Assoc(vm.Intern("a"), int64(2)))
+	testEval(t, s, "(= `(a b) `(a b))", true)
+	testEval(t, s, "(= `(a b) `[a b])", false)
+	testEval(t, s, "(list? `())", true)
+	testEval(t, s, "(symbol? (nth 0 `(a)))", true)
+	testEval(t, s, "(drop 1 `(a b))", vm.NewList([]vm.Val{sym("b")}))
+	testEval(t, s, "(type-of `a)", vm.Intern("symbol"))
+	testEval(t, s, "('(+ ~a 1) 2)", int64(3))
+}
+
+func TestEvalMacroexpand(t *testing.T) {
+	s := session.New([]string{})
+	sym := vm.NewSymbol
+	testEval(t, s, "(defmacro unless [c a b] `(if ~c ~b ~a))", nil)
+	testEval(t, s, "(macroexpand (unless x 1 2))", vm.NewList([]vm.Val{sym("if"), sym("x"), int64(2), int64(1)}))
+}
+
+func TestExpand(t *testing.T) {
+	n, err := session.Expand([]string{}, "t", "(defmacro inc [x] `(+ ~x 1))\n(inc 41)")
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if a := cmp.PrintAst(n); a != "(do (+ 41 1))" {
+		t.Errorf("Expecting [(do (+ 41 1))] but got [%s]", a)
+	}
+	n, err = session.Parse("t", "(inc 41)")
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if a := cmp.PrintAst(n); a != "(inc 41)" {
+		t.Errorf("Expecting [(inc 41)] but got [%s]", a)
+	}
+}
+
+func TestEvalLet(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(let (a 1 a (+ a 1)) a)`, int64(2))
+	testEval(t, s, `((fn [a] (+ (let (a 10) a) a)) 1)`, int64(11))
+	testEval(t, s, `((fn [a b] (let (b (+ a b) c (* b 2)) (+ a b c))) 1 2)`, int64(10))
+	testEval(t, s, `(let (x 1) (+ (let (x 2) x) x))`, int64(3))
+	testEval(t, s, `(let (x 1 f (fn [y] (+ x y))) (f 41))`, int64(42))
+	testEval(t, s, `(((fn [n] (let (m (* n 2)) (fn [] m))) 21))`, int64(42))
+	testEval(t, s, `(let (x 1) (do (set y 2) (+ x y)))`, int64(3))
+	testEval(t, s, `(try (throw 1) (catch x (let (x (+ x 1)) x)))`, int64(2))
+}
+
+func TestEvalLetRecursive(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(let (f (fn [n] (if (= n 0) 1 (* n (f (- n 1)))))) (f 5))`, int64(120))
+	testEval(t, s, `(let (k 2 f (fn [n] (if (= n 0) 0 (+ k (f (- n 1)))))) (f 3))`, int64(6))
+	testEval(t, s, `
+    (letrec (even? (fn [n] (if (= n 0) true (odd? (- n 1))))
+             odd?  (fn [n] (if (= n 0) false (even? (- n 1)))))
+      (even? 10))`, true)
+	testEval(t, s, `(letrec (a 1 f (fn [] (+ a (g))) g (fn [] 2)) (f))`, int64(3))
+}
+
+func TestEvalLoop(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(loop (i 0 acc 1) (if (< i 5) (recur (+ i 1) (* acc 2)) acc))`, int64(32))
+	testEval(t, s, `(loop [i 0] (if (< i 3) (recur (+ i 1)) i))`, int64(3))
+	testEval(t, s, `(def count-to (fn [n] (loop (i 0) (if (< i n) (let (j (+ i 1)) (recur j)) i))))`, nil)
+	testEval(t, s, `(count-to 100000)`, int64(100000))
+	testEval(t, s, `(loop (a 1 b 2) (if (= a 1) (recur b a) [a b]))`, []vm.Val{int64(2), int64(1)})
+	testEval(t, s, `(+ 1 (loop (i 0) (if (< i 3) (recur (+ i 1)) i)))`, int64(4))
+	testEval(t, s, `((fn [n] (loop (i 0) (if (< i n) (recur (+ i 1)) (count-to i)))) 5)`, int64(5))
+	testEval(t, s, `((fn [n] (+ 1 (loop (i n) (count-to i)))) 5)`, int64(6))
+	testEval(t, s, `(loop (i 0) (if (< i 2) (recur (+ i 1)) (loop (j i) (if (< j 5) (recur (+ j 1)) j))))`, int64(5))
+	testEval(t, s, `
+    (let (fs (loop (i 0 fs []) (if (< i 3) (recur (+ i 1) (+. fs (fn [] i))) fs)))
+      [((nth 0 fs)) ((nth 2 fs))])`, []vm.Val{int64(0), int64(2)})
+	testEval(t, s, `
+    (loop (i 0 fs [])
+      (if (< i 2)
+        (recur (+ i 1) (+. fs (fn [] (do (set! i (* i 10)) i))))
+        [((nth 0 fs)) ((nth 1 fs)) i]))`, []vm.Val{int64(0), int64(10), int64(2)})
+}
+
+func TestEvalSetBang(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(let (x 1) (do (set! x (+ x 1)) x))`, int64(2))
+	testEval(t, s, `((fn [x] (do (set! x 5) x)) 1)`, int64(5))
+	testEval(t, s, `(def g 1)`, nil)
+	testEval(t, s, `(do (set! g 2) g)`, int64(2))
+	testEval(t, s, `
+    (def counter
+      (fn []
+        (let (n 0)
+          (fn [] (do (set! n (+ n 1)) n)))))`, nil)
+	testEval(t, s, `(let (c (counter)) (do (c) (c) (c)))`, int64(3))
+	testEval(t, s, `(let (a (counter) b (counter)) (do (a) (a) (b)))`, int64(1))
+	testEval(t, s, `(let (n 0 f (fn [] n)) (do (set! n 42) (f)))`, int64(42))
+	testEval(t, s, `
+    (let (acc []
+          add (fn [x] (set! acc (+. acc x))))
+      (do (add 1) (add 2) acc))`, []vm.Val{int64(1), int64(2)})
+	testEval(t, s, `
+    ((fn [n]
+      (let (inc (fn [] (set! n (+ n 1)))
+            peek (fn [] n))
+        (do (inc) (inc) (peek)))) 40)`, int64(42))
+	testEval(t, s, `(let (n 0 f (fn [] (fn [] (set! n (+ n 1))))) (do ((f)) ((f)) n))`, int64(2))
+	testEval(t, s, `(letrec (n 0 f (fn [] (do (set! n (+ n 1)) (if (< n 5) (f) n)))) (f))`, int64(5))
+	testEval(t, s, `(try (throw 1) (catch e (let (f (fn [] (set! e 2))) (do (f) e))))`, int64(2))
+	if _, _, err := s.Eval("", `(set! unknown 1)`); err == nil {
+		t.Errorf("Expecting an unknown symbol error")
+	}
+}
+
+func TestEvalAtom(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(deref (atom 42))`, int64(42))
+	testEval(t, s, `(def a (atom 0))`, nil)
+	testEval(t, s, `(reset! a 1)`, int64(1))
+	testEval(t, s, `(deref a)`, int64(1))
+	testEval(t, s, `(swap! a + 41)`, int64(42))
+	testEval(t, s, `(swap! a (fn [x] (* x 2)))`, int64(84))
+	testEval(t, s, `(swap! a (fn [x y z] (- (- x y) z)) 1 2)`, int64(81))
+	testEval(t, s, `(def inc-all (fn [c xs] (if (= (len xs) 0) c (do (swap! c + (nth 0 xs)) (inc-all c (drop 1 xs))))))`, nil)
+	testEval(t, s, `(deref (inc-all (atom 0) [1 2 3]))`, int64(6))
+	testEval(t, s, `(let (b (atom 1)) (= b b))`, true)
+	testEval(t, s, `(= (atom 1) (atom 1))`, false)
+	testEval(t, s, `(atom? a)`, true)
+	testEval(t, s, `(atom? 1)`, false)
+	testEval(t, s, `(type-of a)`, vm.Intern("atom"))
+	testEval(t, s, `(swap! (atom [1]) +. 2)`, []vm.Val{int64(1), int64(2)})
+	if _, _, err := s.Eval("", `(deref 1)`); err == nil {
+		t.Errorf("Expecting a type error")
+	}
+	if _, _, err := s.Eval("", `(swap! a)`); err == nil {
+		t.Errorf("Expecting an arity error")
+	}
+	v, _, err := s.Eval("", `(atom 42)`)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if a := fmt.Sprint(v); a != "#<atom 42>" {
+		t.Errorf("Expecting [#<atom 42>] but got [%s]", a)
+	}
+}
+
+func TestEvalMultiArity(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `
+    (def add
+      (fn ([] 0)
+          ([x] x)
+          ([x y] (+ x y))
+          ([x y z & zs] (add (+ x y) z (dissolve zs)))))`, nil)
+	testEval(t, s, `[(add) (add 1) (add 1 2) (add 1 2 3 4)]`, []vm.Val{int64(0), int64(1), int64(3), int64(10)})
+	testEval(t, s, `(let (k 10 f (fn ([] k) ([x] (+ k x)))) [(f) (f 1)])`, []vm.Val{int64(10), int64(11)})
+	testEval(t, s, `(let (f (fn ([n] (f n 1)) ([n acc] (if (= n 0) acc (f (- n 1) (* n acc)))))) (f 5))`, int64(120))
+	testEval(t, s, `((fn [a] (def g a) (+ g 1)) 1)`, int64(2))
+}
+
+func TestEvalDefaultParams(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def f (fn [a (b 2) (c (+ a b))] [a b c]))`, nil)
+	testEval(t, s, `(f 1)`, []vm.Val{int64(1), int64(2), int64(3)})
+	testEval(t, s, `(f 1 5)`, []vm.Val{int64(1), int64(5), int64(6)})
+	testEval(t, s, `(f 1 5 0)`, []vm.Val{int64(1), int64(5), int64(0)})
+	testEval(t, s, `((fn [(a 1) & xs] [a xs]))`, []vm.Val{int64(1), []vm.Val{}})
+	testEval(t, s, `((fn [(a 1) & xs] [a xs]) 2 3)`, []vm.Val{int64(2), []vm.Val{int64(3)}})
+	testEval(t, s, `(let (k 7) ((fn [(a k)] a)))`, int64(7))
+	testEval(t, s, `((fn [(n 0)] (do ((fn [] (set! n (+ n 1)))) n)))`, int64(1))
+}
+
+func TestEvalArityError(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def f (fn ([x] x) ([x y z] z)))`, nil)
+	for _, src := range []string{`(f)`, `(f 1 2)`, `(f 1 2 3 4)`} {
+		_, _, err := s.Eval("", src)
+		if err == nil || !strings.Contains(err.Error(), "[f] does not accept") {
+			t.Errorf("Expecting arity error for [%s] but got [%v]", src, err)
+		}
+	}
+	testEval(t, s, `(get (try (f) (catch e e)) :message)`, "[f] does not accept [0] arguments")
+	testEval(t, s, `(f 1)`, int64(1))
+	testEval(t, s, `(def two (fn [a b] b))`, nil)
+	for _, src := range []string{`(two 1)`, `(two 1 2 3)`, `((fn [] 1) 2)`} {
+		_, _, err := s.Eval("", src)
+		if err == nil || !strings.Contains(err.Error(), "does not accept") {
+			t.Errorf("Expecting arity error for [%s] but got [%v]", src, err)
+		}
+	}
+	testEval(t, s, `(two 1 2)`, int64(2))
+}
+
+func TestEvalArityErrorRange(t *testing.T) {
+	s := session.New([]string{})
+	testEval(t, s, `(def d (fn [a (b 10)] [a b]))`, nil)
+	testEval(t, s, `(def r (fn [a & r] [a r]))`, nil)
+	testEval(t, s, `(def c (fn ([] 0) ([a b & r] [a b r])))`, nil)
+	for _, src := range []string{`(d)`, `(d 1 2 3)`, `(r)`, `(c 1)`} {
+		_, _, err := s.Eval("", src)
+		if err == nil || !strings.Contains(err.Error(), "does not accept") {
+			t.Errorf("Expecting arity error for [%s] but got [%v]", src, err)
+		}
+	}
+	testEval(t, s, `[(d 1) (r 1) (c)]`, []vm.Val{
+		[]vm.Val{int64(1), int64(10)},
+		[]vm.Val{int64(1), []vm.Val{}},
+		int64(0),
+	})
+}
+
+func TestEvalNative(t *testing.T) {
+	s := session.New([]string{})
+	s.Register(vm.NewNative("twice", 1, func(args []vm.Val) (vm.Val, error) {
+		return 2 * args[0].(int64), nil
+	}))
+	testEval(t, s, `(twice 21)`, int64(42))
+	testEval(t, s, `(def apply (fn [f x] (f x)))`, nil)
+	testEval(t, s, `(apply twice 21)`, int64(42))
+	testEval(t, s, `((fn [x] (twice x)) 21)`, int64(42))
+	if _, _, err := s.Eval("", `(twice 1 2)`); err == nil {
+		t.Errorf("Expecting an arity error")
+	}
+}
+
+func TestCompileProgramNative(t *testing.T) {
+	n := vm.NewNative("answer", 0, func(args []vm.Val) (vm.Val, error) {
+		return int64(42), nil
+	})
+	prg, err := session.Compile([]string{}, "t", `(answer)`, n)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	m := vm.NewVM(1024, 512, 512)
+	m.AddDefaultGlobals()
+	prg.Link(m, n)
+	if err := m.Run(prg.Code); err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if v := m.InspectStack(0); v != int64(42) {
+		t.Errorf("Expecting [42] but got [%v]", v)
+	}
+}
+
+func TestCompileProgram(t *testing.T) {
+	home := util.SplisHomePath()
+	prg, err := session.Compile([]string{home}, "t", `(do (use "test/prelude") (inc 41))`)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if len(prg.Modules) != 1 || prg.Modules[0] != path.Join(home, "test/prelude.splis") {
+		t.Errorf("Unexpected modules %v", prg.Modules)
+	}
+	m := vm.NewVM(1024, 512, 512)
+	if err := m.Run(prg.Code); err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if v := m.InspectStack(0); v != int64(42) {
+		t.Errorf("Expecting [42] but got [%v]", v)
+	}
+}
+
+func TestEvalMacroCallsPrelude(t *testing.T) {
+	s := session.New([]string{util.SplisHomePath()})
+	testEval(t, s, `(use "test/prelude")`, nil)
+	testEval(t, s, `(defmacro twice [x] (inc (inc x)))`, nil)
+	testEval(t, s, `(twice 1)`, int64(3))
+	testEval(t, s, `(def sq (fn [x] (* x x)))`, nil)
+	testEval(t, s, `(defmacro sq4 [] (sq 4))`, nil)
+	testEval(t, s, `(sq4)`, int64(16))
+}
+
+func TestComplete(t *testing.T) {
+	testComplete(t, ``, true)
+	testComplete(t, `(+ 1 2)`, true)
+	testComplete(t, `(+ 1`, false)
+	testComplete(t, "(fn [x]\n  (+ x", false)
+	testComplete(t, `[1 (2)`, false)
+	testComplete(t, `"abc`, false)
+	testComplete(t, `"ab\"`, false)
+	testComplete(t, `"ab\\"`, true)
+	testComplete(t, `"(" `, true)
+	testComplete(t, `(+ 1 2)) `, true)
+	testComplete(t, `(+ 1 ; )`, false)
+}
+
+func testEval(t *testing.T, s *session.Session, src string, e vm.Val) {
+	t.Helper()
+	v, ok, err := s.Eval("", src)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if e == nil {
+		if ok {
+			t.Errorf("Expecting no result but got [%v]", v)
+		}
+		return
+	}
+	if !reflect.DeepEqual(e, v) {
+		t.Errorf("Expecting [%v] but got [%v]", e, v)
+	}
+}
+
+func testComplete(t *testing.T, src string, e bool) {
+	t.Helper()
+	if a := session.Complete(src); a != e {
+		t.Errorf("Expecting [%v] for [%s] but got [%v]", e, src, a)
+	}
+}
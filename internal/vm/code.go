@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 )
 
 // Op defines the type of the opcode as a byte. Hence there are 256 different
@@ -21,6 +22,9 @@ const (
 	OpEmptyList
 	OpEmptyVector
 	OpStr
+	OpKeyword
+	OpSymbol
+	OpNil
 
 	OpAdd
 	OpSub
@@ -30,6 +34,7 @@ const (
 	OpRand
 
 	OpList
+	OpMakeList
 	OpCons
 	OpAppend
 	OpConcat
@@ -48,11 +53,25 @@ const (
 	OpJoin
 	OpExplode
 
+	OpMap
+	OpGet
+	OpAssoc
+	OpDissoc
+	OpContains
+	OpKeys
+	OpVals
+
+	OpAtom
+	OpDeref
+	OpReset
+
 	OpNot
 	OpEQ
 	OpNE
 	OpLT
 	OpLE
+	OpIs
+	OpTypeOf
 
 	OpJump
 	OpJumpIf
@@ -67,13 +86,23 @@ const (
 	OpPushArgs
 	OpDropArgs
 	OpGetArg
+	OpSetArg
+	OpMakeCell
+	OpGetCell
+	OpSetCell
 
 	OpRef
+	OpPatchRef
 	OpCall
-	OpRecCall
-	// OpTailCall
+	OpTailCall
 	OpReturn
 	OpEnd
+	OpArgc
+	OpArityError
+
+	OpTry
+	OpEndTry
+	OpThrow
 
 	OpRead
 	OpWrite
@@ -91,6 +120,22 @@ const (
 	DbgFrames = uint64(1 << 1)
 )
 
+// Arguments to OpIs.
+const (
+	TypeBool = uint64(iota)
+	TypeInt
+	TypeFloat
+	TypeStr
+	TypeVec
+	TypeFn
+	TypeMap
+	TypeNil
+	TypeKeyword
+	TypeList
+	TypeSymbol
+	TypeAtom
+)
+
 // OpMeta contains the human-readable name of the operation and the length in
 // bytes of each of its arguments.
 type OpMeta struct {
@@ -106,6 +151,9 @@ var meta = map[Op]*OpMeta{
 	OpEmptyList:   {"EmptyList", []int{}},
 	OpEmptyVector: {"EmptyVector", []int{}},
 	OpStr:         {"String", []int{8}},
+	OpKeyword:     {"Keyword", []int{8}},
+	OpSymbol:      {"Symbol", []int{8}},
+	OpNil:         {"Nil", []int{}},
 
 	OpAdd:  {"Add", []int{}},
 	OpSub:  {"Sub", []int{}},
@@ -115,17 +163,30 @@ var meta = map[Op]*OpMeta{
 	OpRand: {"Rand", []int{}},
 
 	OpList:     {"List", []int{}},
+	OpMakeList: {"MakeList", []int{}},
 	OpCons:     {"Cons", []int{}},
 	OpAppend:   {"Append", []int{}},
 	OpConcat:   {"Concat", []int{}},
 	OpNth:      {"Nth", []int{}},
 	OpDrop:     {"Drop", []int{}},
-	OpLength:   {"Tail", []int{}},
+	OpLength:   {"Length", []int{}},
 	OpDissolve: {"Dissolve", []int{}},
 
 	OpJoin:    {"Join", []int{}},
 	OpExplode: {"Explode", []int{}},
 
+	OpMap:      {"Map", []int{}},
+	OpGet:      {"Get", []int{}},
+	OpAssoc:    {"Assoc", []int{}},
+	OpDissoc:   {"Dissoc", []int{}},
+	OpContains: {"Contains", []int{}},
+	OpKeys:     {"Keys", []int{}},
+	OpVals:     {"Vals", []int{}},
+
+	OpAtom:  {"Atom", []int{}},
+	OpDeref: {"Deref", []int{}},
+	OpReset: {"Reset", []int{}},
+
 	// OpAnd:         {"And", []int{}},
 	// OpOr:          {"Or", []int{}},
 	// OpInv:         {"Inv", []int{}},
@@ -137,6 +198,8 @@ var meta = map[Op]*OpMeta{
 	OpNE:        {"NE", []int{}},
 	OpLT:        {"LT", []int{}},
 	OpLE:        {"LE", []int{}},
+	OpIs:        {"Is", []int{8}},
+	OpTypeOf:    {"TypeOf", []int{}},
 	OpJump:      {"Jump", []int{8}},
 	OpJumpIf:    {"JumpIf", []int{8}},
 	OpJumpIfNot: {"JumpIfNot", []int{8}},
@@ -149,16 +212,27 @@ var meta = map[Op]*OpMeta{
 	OpPushArgs: {"PushArgs", []int{8}},
 	OpDropArgs: {"DropArgs", []int{8}},
 	OpGetArg:   {"GetArg", []int{8}},
+	OpSetArg:   {"SetArg", []int{8}},
+	OpMakeCell: {"MakeCell", []int{}},
+	OpGetCell:  {"GetCell", []int{}},
+	OpSetCell:  {"SetCell", []int{}},
+
+	OpRef:      {"Ref", []int{8, 8}},
+	OpPatchRef: {"PatchRef", []int{8}},
+	OpCall:     {"Call", []int{}},
+	OpTailCall: {"TailCall", []int{}},
+	OpReturn:   {"Return", []int{}},
 
-	OpRef:     {"Ref", []int{8, 8}},
-	OpCall:    {"Call", []int{}},
-	OpRecCall: {"RecCall", []int{}},
-	OpReturn:  {"Return", []int{}},
+	OpArgc:       {"Argc", []int{8}},
+	OpArityError: {"ArityError", []int{8}},
 
 	OpRead:  {"Read", []int{}},
 	OpWrite: {"Write", []int{}},
 
 	OpEnd:     {"End", []int{}},
+	OpTry:     {"Try", []int{8}},
+	OpEndTry:  {"EndTry", []int{}},
+	OpThrow:   {"Throw", []int{}},
 	OpHalt:    {"Halt", []int{}},
 	OpRuntime: {"Runtime", []int{}},
 	OpDebug:   {"Debug", []int{8}},
@@ -183,6 +257,36 @@ func LookupMeta(op Op) (*OpMeta, error) {
 	return nil, fmt.Errorf("opcode [%d] undefined", op)
 }
 
+// LookupOp returns the opcode with the human-readable name or an error if
+// there is no such operation.
+func LookupOp(name string) (Op, error) {
+	for op, m := range meta {
+		if m.Name == name {
+			return op, nil
+		}
+	}
+	return 0, fmt.Errorf("operation [%s] undefined", name)
+}
+
+var opTableVersion = computeOpTableVersion()
+
+// OpTableVersion identifies the instruction set. It is a checksum of the
+// opcodes, their names and the sizes of their arguments. Code compiled for a
+// different instruction set cannot be executed.
+func OpTableVersion() uint32 {
+	return opTableVersion
+}
+
+func computeOpTableVersion() uint32 {
+	var buf bytes.Buffer
+	for op := 0; op < 256; op++ {
+		if m, ok := meta[Op(op)]; ok {
+			fmt.Fprintf(&buf, "%d %s %v\n", op, m.Name, m.Args)
+		}
+	}
+	return crc32.ChecksumIEEE(buf.Bytes())
+}
+
 // Instr creates a new instruction from an opcode and a variable number of
 // arguments.
 func Instr(op Op, args ...uint64) []byte {
@@ -209,11 +313,16 @@ func Instr(op Op, args ...uint64) []byte {
 }
 
 func Str(s string) []byte {
+	return StrInstr(OpStr, s)
+}
+
+// StrInstr creates an instruction for an operation with a string operand.
+func StrInstr(op Op, s string) []byte {
 	b := []byte(s)
 	ln := len(b)
 	sz := 9 + ln
 	ins := make([]byte, sz)
-	ins[0] = OpStr
+	ins[0] = op
 	binary.BigEndian.PutUint64(ins[1:9], uint64(ln))
 	copy(ins[9:sz], b)
 	return ins
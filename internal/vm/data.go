@@ -1,5 +1,10 @@
 package vm
 
+import (
+	"fmt"
+	"os"
+)
+
 type Val interface{}
 
 type Env map[int64]Val
@@ -16,3 +21,86 @@ func NewRef(addr int64) *Ref {
 func (r *Ref) Add(v Val) {
 	r.cargs = append(r.cargs, v)
 }
+
+// Cell holds the value of a local binding that is captured by closures and
+// mutated. The closures share the cell and see each other's mutations.
+type Cell struct {
+	Val Val
+}
+
+func (c *Cell) String() string {
+	return fmt.Sprintf("#<cell %v>", c.Val)
+}
+
+// Atom is a mutable reference that can be passed around. Atoms are equal only
+// if they are identical.
+type Atom struct {
+	Val Val
+}
+
+func (a *Atom) String() string {
+	return fmt.Sprintf("#<atom %v>", a.Val)
+}
+
+// Nil is the type of the nil value. The nil value is distinct from the Go nil
+// that marks the end of a variable number of arguments on the stack.
+type Nil struct{}
+
+func (Nil) String() string {
+	return "nil"
+}
+
+// typeNames contains the names of the types that OpIs can test for indexed by
+// their type codes.
+var typeNames = []string{
+	TypeBool:    "bool",
+	TypeInt:     "int",
+	TypeFloat:   "float",
+	TypeStr:     "string",
+	TypeVec:     "vector",
+	TypeFn:      "fn",
+	TypeMap:     "map",
+	TypeNil:     "nil",
+	TypeKeyword: "keyword",
+	TypeList:    "list",
+	TypeSymbol:  "symbol",
+	TypeAtom:    "atom",
+}
+
+// TypeName returns a human-readable name for the type of the value v.
+func TypeName(v Val) string {
+	switch v.(type) {
+	case nil:
+		return "end"
+	case Nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []Val:
+		return "vector"
+	case *Map:
+		return "map"
+	case *Keyword:
+		return "keyword"
+	case *List:
+		return "list"
+	case *Symbol:
+		return "symbol"
+	case *Ref, *Native:
+		return "fn"
+	case *os.File:
+		return "file"
+	case *Cell:
+		return "cell"
+	case *Atom:
+		return "atom"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
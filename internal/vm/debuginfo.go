@@ -0,0 +1,186 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// SrcPos is a location in a source file.
+type SrcPos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p SrcPos) String() string {
+	switch {
+	case p.Line == 0:
+		return "-"
+	case p.File == "":
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+	}
+}
+
+// FnInfo describes the code range [Start, End) of a compiled function.
+type FnInfo struct {
+	Name  string
+	Start int64
+	End   int64
+	Pos   SrcPos
+}
+
+// LineInfo maps the code address Addr and all subsequent addresses up to the
+// next entry to a source position.
+type LineInfo struct {
+	Addr int64
+	Pos  SrcPos
+}
+
+// DebugInfo maps code addresses to function names and source positions.
+type DebugInfo struct {
+	Fns   []FnInfo
+	Lines []LineInfo
+}
+
+func NewDebugInfo() *DebugInfo {
+	return &DebugInfo{
+		Fns:   make([]FnInfo, 0),
+		Lines: make([]LineInfo, 0),
+	}
+}
+
+// Merge appends the entries of o. The code described by o is expected to
+// follow the code described by d.
+func (d *DebugInfo) Merge(o *DebugInfo) {
+	d.Fns = append(d.Fns, o.Fns...)
+	d.Lines = append(d.Lines, o.Lines...)
+}
+
+// FnAt returns the innermost function that contains the code address ip.
+func (d *DebugInfo) FnAt(ip int64) (*FnInfo, bool) {
+	var fn *FnInfo
+	for i := range d.Fns {
+		f := &d.Fns[i]
+		if f.Start <= ip && ip < f.End {
+			if fn == nil || f.End-f.Start < fn.End-fn.Start {
+				fn = f
+			}
+		}
+	}
+	return fn, fn != nil
+}
+
+// PosAt returns the source position of the code address ip. Line entries are
+// expected to be sorted by address.
+func (d *DebugInfo) PosAt(ip int64) (SrcPos, bool) {
+	pos, ok := SrcPos{}, false
+	for _, l := range d.Lines {
+		if l.Addr > ip {
+			break
+		}
+		pos, ok = l.Pos, true
+	}
+	return pos, ok
+}
+
+// Encode serializes the debug information.
+func (d *DebugInfo) Encode() []byte {
+	var buf bytes.Buffer
+	writeUint64(&buf, uint64(len(d.Fns)))
+	for _, f := range d.Fns {
+		writeString(&buf, f.Name)
+		writeUint64(&buf, uint64(f.Start))
+		writeUint64(&buf, uint64(f.End))
+		writeSrcPos(&buf, f.Pos)
+	}
+	writeUint64(&buf, uint64(len(d.Lines)))
+	for _, l := range d.Lines {
+		writeUint64(&buf, uint64(l.Addr))
+		writeSrcPos(&buf, l.Pos)
+	}
+	return buf.Bytes()
+}
+
+// DecodeDebugInfo deserializes debug information created by Encode.
+func DecodeDebugInfo(b []byte) (d *DebugInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed debug information")
+		}
+	}()
+	r := &byteReader{b: b}
+	d = NewDebugInfo()
+	for n := r.uint64(); n > 0; n-- {
+		f := FnInfo{}
+		f.Name = r.string()
+		f.Start = int64(r.uint64())
+		f.End = int64(r.uint64())
+		f.Pos = r.srcPos()
+		d.Fns = append(d.Fns, f)
+	}
+	for n := r.uint64(); n > 0; n-- {
+		l := LineInfo{}
+		l.Addr = int64(r.uint64())
+		l.Pos = r.srcPos()
+		d.Lines = append(d.Lines, l)
+	}
+	return d, nil
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUint64(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeSrcPos(buf *bytes.Buffer, p SrcPos) {
+	writeString(buf, p.File)
+	writeUint64(buf, uint64(p.Line))
+	writeUint64(buf, uint64(p.Col))
+}
+
+// byteReader reads the values written by the write functions above. It panics
+// if the input is too short.
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *byteReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.bytes(8))
+}
+
+func (r *byteReader) string() string {
+	return string(r.bytes(int(r.uint64())))
+}
+
+func (r *byteReader) srcPos() SrcPos {
+	file := r.string()
+	line := int(r.uint64())
+	col := int(r.uint64())
+	return SrcPos{File: file, Line: line, Col: col}
+}
+
+func (r *byteReader) byte() byte {
+	v := r.b[r.pos]
+	r.pos++
+	return v
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	// The slice expression only checks the capacity.
+	if n < 0 || r.pos+n > len(r.b) {
+		panic("input too short")
+	}
+	v := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return v
+}
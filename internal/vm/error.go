@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuntimeError describes a failure during the execution of a program. It
+// records the operation that failed, the address of that instruction and the
+// operand values involved. Depth is the size of the stack that overflowed in
+// case of a stack overflow. Value is the thrown value of an uncaught throw.
+type RuntimeError struct {
+	Op    Op
+	IP    int64
+	Args  []Val
+	Msg   string
+	Trace []Frame
+	Depth int64
+	Value Val
+}
+
+// maxTrace limits the number of frames in a backtrace. Deep recursions would
+// produce huge traces otherwise.
+const maxTrace = 64
+
+// Frame is an entry of the backtrace of a runtime error. It names the active
+// function and the location of the failed instruction or the call.
+type Frame struct {
+	Name string
+	IP   int64
+	Pos  SrcPos
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("at %s [%d] (%s)", f.Name, f.IP, f.Pos)
+}
+
+func (e *RuntimeError) Error() string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("runtime error at [%d] in [%s]: %s", e.IP, opName(e.Op), e.Msg))
+	if len(e.Args) > 0 {
+		buf.WriteString(" (operands:")
+		for _, arg := range e.Args {
+			buf.WriteString(fmt.Sprintf(" [%v]", arg))
+		}
+		buf.WriteString(")")
+	}
+	return buf.String()
+}
+
+// Backtrace returns the active functions at the time of the failure starting
+// with the innermost function. Each function is printed on a separate line.
+func (e *RuntimeError) Backtrace() string {
+	var buf strings.Builder
+	for _, f := range e.Trace {
+		buf.WriteString("  ")
+		buf.WriteString(f.String())
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// error creates a new runtime error for the currently executed instruction.
+// The operands are the values the instruction failed on. The backtrace is
+// only recorded if no handler is installed. A handler catches the error and
+// would discard it.
+func (m *VM) error(args []Val, format string, a ...interface{}) *RuntimeError {
+	e := &RuntimeError{
+		Op:   m.code[m.lip],
+		IP:   m.lip,
+		Args: args,
+		Msg:  fmt.Sprintf(format, a...),
+	}
+	if len(m.handlers) == 0 {
+		e.Trace = m.backtrace(m.lip)
+	}
+	return e
+}
+
+// backtrace unwinds the frames stack starting at the instruction ip. Every
+// function call saved the return address and the previous frame pointer
+// right below the frame of the called function.
+func (m *VM) backtrace(ip int64) []Frame {
+	trace := []Frame{m.frameAt(ip)}
+	for fp := m.fp; fp >= 2 && fp <= m.fsp && len(trace) < maxTrace; {
+		rip, ok := m.frames[fp-2].(int64)
+		if !ok {
+			break
+		}
+		pfp, ok := m.frames[fp-1].(int64)
+		if !ok || pfp >= fp {
+			break
+		}
+		// The call instruction precedes the return address.
+		trace = append(trace, m.frameAt(rip-1))
+		fp = pfp
+	}
+	return trace
+}
+
+func (m *VM) frameAt(ip int64) Frame {
+	f := Frame{Name: "?", IP: ip}
+	if m.dbg == nil {
+		return f
+	}
+	if fn, ok := m.dbg.FnAt(ip); ok {
+		f.Name = fn.Name
+	} else {
+		f.Name = "<top>"
+	}
+	f.Pos, _ = m.dbg.PosAt(ip)
+	return f
+}
+
+// typeError creates a runtime error for a value v that does not have the
+// expected type.
+func (m *VM) typeError(exp string, v Val) *RuntimeError {
+	return m.error([]Val{v}, "expecting [%s] but got [%s]", exp, TypeName(v))
+}
+
+func opName(op Op) string {
+	if meta, err := LookupMeta(op); err == nil {
+		return meta.Name
+	}
+	return fmt.Sprintf("Invalid [%d]", op)
+}
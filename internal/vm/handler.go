@@ -0,0 +1,60 @@
+package vm
+
+// handler is an exception handler that has been installed by a try block. It
+// records the address of the catch block and the state of the stacks when
+// the try block has been entered.
+type handler struct {
+	ip  int64
+	sp  int64
+	fp  int64
+	fsp int64
+}
+
+func (m *VM) pushHandler(ip int64) {
+	m.handlers = append(m.handlers, handler{ip: ip, sp: m.sp, fp: m.fp, fsp: m.fsp})
+}
+
+func (m *VM) popHandler() {
+	m.handlers = m.handlers[:len(m.handlers)-1]
+}
+
+// throw creates the error for a thrown value. The error ends the program
+// unless a handler catches it.
+func (m *VM) throw(v Val) *RuntimeError {
+	e := m.error([]Val{v}, "uncaught exception")
+	e.Value = v
+	return e
+}
+
+// catch transfers control to the innermost handler. The stack and the frames
+// stack are unwound to their state at the start of the try block and the
+// exception is pushed onto the stack. It returns false if there is no
+// handler for the error.
+func (m *VM) catch(err error) bool {
+	e, ok := err.(*RuntimeError)
+	if !ok || len(m.handlers) == 0 {
+		return false
+	}
+	h := m.handlers[len(m.handlers)-1]
+	m.popHandler()
+	m.sp = h.sp
+	m.fp = h.fp
+	m.fsp = h.fsp
+	m.ip = h.ip
+	m.push(exception(e))
+	return true
+}
+
+// exception returns the value that a catch block receives for the error e.
+// Errors of the machine itself are hash maps that contain the error message
+// and the name of the failed operation.
+//
+//	{:message "division by zero" :op "Div"}
+func exception(e *RuntimeError) Val {
+	if e.Value != nil {
+		return e.Value
+	}
+	return NewMap().
+		Assoc(Intern("message"), e.Msg).
+		Assoc(Intern("op"), opName(e.Op))
+}
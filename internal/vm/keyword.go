@@ -0,0 +1,32 @@
+package vm
+
+import "sync"
+
+// Keyword is a symbolic constant such as :foo. Keywords are interned: there
+// is exactly one keyword for each name so that keywords can be compared by
+// identity.
+type Keyword struct {
+	Name string
+}
+
+var keywords = struct {
+	sync.Mutex
+	m map[string]*Keyword
+}{m: map[string]*Keyword{}}
+
+// Intern returns the keyword for name. The name does not include the leading
+// colon.
+func Intern(name string) *Keyword {
+	keywords.Lock()
+	defer keywords.Unlock()
+	if k, ok := keywords.m[name]; ok {
+		return k
+	}
+	k := &Keyword{Name: name}
+	keywords.m[name] = k
+	return k
+}
+
+func (k *Keyword) String() string {
+	return ":" + k.Name
+}
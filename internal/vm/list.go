@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Symbol is a symbol as runtime value. Symbols are created by quasi-quoted
+// code and passed to macros. Symbols with the same name are equal. Unlike
+// keywords, symbols are not interned. Each symbol of the code passed to a
+// macro is a distinct value. The macro rewriter uses its identity to find the
+// node it came from along with its source position.
+type Symbol struct {
+	Name string
+}
+
+func NewSymbol(name string) *Symbol {
+	return &Symbol{Name: name}
+}
+
+func (s *Symbol) String() string {
+	return s.Name
+}
+
+// List is a list as runtime value. Lists are created by quasi-quoted code and
+// behave like vectors except that they keep being lists. Lists are never
+// modified in place.
+type List struct {
+	Items []Val
+}
+
+func NewList(items []Val) *List {
+	return &List{Items: items}
+}
+
+func (l *List) String() string {
+	var b strings.Builder
+	b.WriteString("(")
+	for i, v := range l.Items {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprint(&b, v)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// popSeq pops a vector or a list. It returns the items and whether the value
+// has been a list.
+func (m *VM) popSeq() ([]Val, bool) {
+	switch x := m.pop().(type) {
+	case []Val:
+		return x, false
+	case *List:
+		return x.Items, true
+	default:
+		panic(m.typeError("vector or list", x))
+	}
+}
+
+// seq returns the items as list if isList is set and as vector otherwise.
+func seq(items []Val, isList bool) Val {
+	if isList {
+		return NewList(items)
+	}
+	return items
+}
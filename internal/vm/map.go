@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Map is an immutable hash map. Any value can be a key. Keys that are equal
+// according to the = primitive address the same entry. The entries keep the
+// order in which their keys have been added.
+type Map struct {
+	keys  []Val
+	vals  []Val
+	index map[interface{}]int
+}
+
+func NewMap() *Map {
+	return &Map{
+		keys:  []Val{},
+		vals:  []Val{},
+		index: map[interface{}]int{},
+	}
+}
+
+// Len returns the number of entries.
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Get returns the value for key k.
+func (m *Map) Get(k Val) (Val, bool) {
+	if i, ok := m.index[hashKey(k)]; ok {
+		return m.vals[i], true
+	}
+	return nil, false
+}
+
+// Assoc returns a copy of the map with k mapped to v.
+func (m *Map) Assoc(k Val, v Val) *Map {
+	n := m.copy()
+	n.set(k, v)
+	return n
+}
+
+// Dissoc returns a copy of the map without the key k.
+func (m *Map) Dissoc(k Val) *Map {
+	n := NewMap()
+	h := hashKey(k)
+	for i, key := range m.keys {
+		if hashKey(key) != h {
+			n.set(key, m.vals[i])
+		}
+	}
+	return n
+}
+
+// Keys returns the keys in insertion order.
+func (m *Map) Keys() []Val {
+	return append([]Val{}, m.keys...)
+}
+
+// Vals returns the values in the order of their keys.
+func (m *Map) Vals() []Val {
+	return append([]Val{}, m.vals...)
+}
+
+func (m *Map) String() string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprint(&b, k, " ", m.vals[i])
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func (m *Map) copy() *Map {
+	n := &Map{
+		keys:  append([]Val{}, m.keys...),
+		vals:  append([]Val{}, m.vals...),
+		index: make(map[interface{}]int, len(m.index)),
+	}
+	for h, i := range m.index {
+		n.index[h] = i
+	}
+	return n
+}
+
+// set adds or replaces an entry in place. It must only be used on maps that
+// have not been handed out yet.
+func (m *Map) set(k Val, v Val) {
+	h := hashKey(k)
+	if i, ok := m.index[h]; ok {
+		m.vals[i] = v
+		return
+	}
+	m.index[h] = len(m.keys)
+	m.keys = append(m.keys, k)
+	m.vals = append(m.vals, v)
+}
+
+// compositeKey is the hash key of vectors, lists, maps and symbols. It is a distinct type so
+// that it never collides with a string key.
+type compositeKey string
+
+// hashKey maps a value to a comparable Go value. Values that are equal have
+// the same hash key. Integral floats map to integers because (= 1 1.0) holds.
+// Functions and other reference values are compared by identity.
+func hashKey(v Val) interface{} {
+	switch x := v.(type) {
+	case float64:
+		if i := int64(x); float64(i) == x {
+			return i
+		}
+		return x
+	case []Val, *List, *Map, *Symbol:
+		var b strings.Builder
+		writeKey(&b, v)
+		return compositeKey(b.String())
+	default:
+		return v
+	}
+}
+
+func writeKey(b *strings.Builder, v Val) {
+	switch x := v.(type) {
+	case bool:
+		b.WriteString(strconv.FormatBool(x))
+	case int64:
+		b.WriteString(strconv.FormatInt(x, 10))
+	case float64:
+		if i := int64(x); float64(i) == x {
+			b.WriteString(strconv.FormatInt(i, 10))
+		} else {
+			b.WriteString(strconv.FormatFloat(x, 'g', -1, 64))
+		}
+	case string:
+		b.WriteString(strconv.Quote(x))
+	case Nil, *Keyword:
+		fmt.Fprint(b, x)
+	case *Symbol:
+		b.WriteString("'")
+		b.WriteString(x.Name)
+	case []Val:
+		b.WriteString("[")
+		for _, e := range x {
+			writeKey(b, e)
+			b.WriteString(" ")
+		}
+		b.WriteString("]")
+	case *List:
+		b.WriteString("(")
+		for _, e := range x.Items {
+			writeKey(b, e)
+			b.WriteString(" ")
+		}
+		b.WriteString(")")
+	case *Map:
+		// The keys of equal maps may have been added in different orders.
+		es := make([]string, x.Len())
+		for i, k := range x.keys {
+			var e strings.Builder
+			writeKey(&e, k)
+			e.WriteString(" ")
+			writeKey(&e, x.vals[i])
+			es[i] = e.String()
+		}
+		sort.Strings(es)
+		b.WriteString("{")
+		b.WriteString(strings.Join(es, ","))
+		b.WriteString("}")
+	default:
+		fmt.Fprintf(b, "%T@%p", x, x)
+	}
+}
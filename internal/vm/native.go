@@ -0,0 +1,61 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Variadic is the arity of functions that accept any number of arguments.
+const Variadic = -1
+
+// NativeFn is the signature of functions implemented in Go.
+type NativeFn func(args []Val) (Val, error)
+
+// Native is a function implemented in Go. It is a value like any other
+// function and can be stored, passed around and called from Splis code.
+type Native struct {
+	Name  string
+	Arity int
+	Fn    NativeFn
+}
+
+// NewNative creates a native function. The arity is the exact number of
+// arguments the function expects or Variadic.
+func NewNative(name string, arity int, fn NativeFn) *Native {
+	return &Native{Name: name, Arity: arity, Fn: fn}
+}
+
+func (n *Native) String() string {
+	return fmt.Sprintf("#<native %s>", n.Name)
+}
+
+// callNative pops the arguments up to the end marker, invokes the native
+// function and returns its result. Errors of the function are turned into
+// runtime errors.
+func (m *VM) callNative(n *Native) Val {
+	args := []Val{}
+	for v := m.pop(); v != end; v = m.pop() {
+		args = append(args, v)
+	}
+	v, err := n.call(args)
+	if err != nil {
+		panic(m.error(args, "[%s] %v", n.Name, err))
+	}
+	return v
+}
+
+// call invokes the native function after checking the number of arguments.
+// A function that returns no value fails as well.
+func (n *Native) call(args []Val) (Val, error) {
+	if n.Arity != Variadic && n.Arity != len(args) {
+		return nil, fmt.Errorf("expects [%d] arguments but got [%d]", n.Arity, len(args))
+	}
+	v, err := n.Fn(args)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, errors.New("returned no value")
+	}
+	return v, nil
+}
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// An object file starts with a header followed by any number of sections:
+//
+//	magic          4 bytes   "NOB\x00"
+//	format         uint16    ObjectFormatVersion
+//	opcodes        uint32    OpTableVersion() of the compiler
+//	sections       ...       id uint8, size uint64, payload
+//
+// Readers skip sections they do not know.
+//
+// There is no section for constants. Numbers, strings, keywords and symbols
+// are immediate operands of the Const, ConstF, String, Keyword and Symbol
+// instructions and thus part of the code section. A separate constant pool
+// would require instructions that load constants by index and a pool that
+// grows along with the code that Append adds to a running machine. A later
+// format version may add the section along with these instructions.
+var objectMagic = []byte{'N', 'O', 'B', 0}
+
+// ObjectFormatVersion is the version of the object file layout. It changes
+// whenever the layout of the header or of a section changes.
+const ObjectFormatVersion = 1
+
+// Section identifiers of the object file.
+const (
+	SectionCode    = 1
+	SectionGlobals = 2
+	SectionDebug   = 3
+)
+
+// Object is the content of an object file: the code of the program, the names
+// of its global definitions indexed by their IDs and optional debug
+// information.
+type Object struct {
+	Code    Ins
+	Globals []string
+	Debug   *DebugInfo
+}
+
+// Encode serializes the object including the header.
+func (o *Object) Encode() []byte {
+	var buf bytes.Buffer
+	buf.Write(objectMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(ObjectFormatVersion))
+	binary.Write(&buf, binary.BigEndian, OpTableVersion())
+
+	writeSection(&buf, SectionCode, o.Code)
+
+	var globals bytes.Buffer
+	writeUint64(&globals, uint64(len(o.Globals)))
+	for _, name := range o.Globals {
+		writeString(&globals, name)
+	}
+	writeSection(&buf, SectionGlobals, globals.Bytes())
+
+	if o.Debug != nil {
+		writeSection(&buf, SectionDebug, o.Debug.Encode())
+	}
+	return buf.Bytes()
+}
+
+func writeSection(buf *bytes.Buffer, id byte, payload []byte) {
+	buf.WriteByte(id)
+	writeUint64(buf, uint64(len(payload)))
+	buf.Write(payload)
+}
+
+// DecodeObject deserializes an object created by Encode. It refuses files
+// that have not been written by the same object format or for a different
+// instruction set.
+func DecodeObject(b []byte) (o *Object, err error) {
+	if len(b) < 10 || !bytes.Equal(b[:4], objectMagic) {
+		return nil, fmt.Errorf("not a noodles object file")
+	}
+	if v := binary.BigEndian.Uint16(b[4:6]); v != ObjectFormatVersion {
+		return nil, fmt.Errorf("unsupported object file format [%d], expecting [%d]", v, ObjectFormatVersion)
+	}
+	if v := binary.BigEndian.Uint32(b[6:10]); v != OpTableVersion() {
+		return nil, fmt.Errorf("object file compiled for instruction set [%08x] but this machine runs [%08x]; recompile the program", v, OpTableVersion())
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			o, err = nil, fmt.Errorf("malformed object file")
+		}
+	}()
+	r := &byteReader{b: b, pos: 10}
+	o = &Object{}
+	hasCode := false
+	for r.pos < len(r.b) {
+		id := r.byte()
+		payload := r.bytes(int(r.uint64()))
+		switch id {
+		case SectionCode:
+			o.Code = payload
+			hasCode = true
+		case SectionGlobals:
+			s := &byteReader{b: payload}
+			for n := s.uint64(); n > 0; n-- {
+				o.Globals = append(o.Globals, s.string())
+			}
+		case SectionDebug:
+			if o.Debug, err = DecodeDebugInfo(payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !hasCode {
+		return nil, fmt.Errorf("object file has no code section")
+	}
+	return o, nil
+}
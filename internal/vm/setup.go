@@ -5,12 +5,22 @@ import "os"
 // AddGlobal assigns a value to an ID in the global definitions.
 // NOTE: Every definition has to be registerd in the compiler as well.
 func (m *VM) AddGlobal(id uint64, val Val) {
-	m.defs[id] = val
+	m.setGlobal(int64(id), val)
 }
 
 func (m *VM) AddDefaultGlobals() {
-
 	m.AddGlobal(0, os.Stdin)  // *STD-IN*
 	m.AddGlobal(1, os.Stdout) // *STD-OUT*
 	m.AddGlobal(2, os.Stderr) // *STD-ERR*
+	m.AddGlobal(3, []Val{})   // *ARGS*
+}
+
+// SetArgs provides the command line arguments of the program as a vector of
+// strings in *ARGS*.
+func (m *VM) SetArgs(args []string) {
+	vs := make([]Val, 0, len(args))
+	for _, arg := range args {
+		vs = append(vs, arg)
+	}
+	m.AddGlobal(3, vs)
 }
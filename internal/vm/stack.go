@@ -0,0 +1,76 @@
+package vm
+
+// The stacks grow on demand up to these limits by default.
+const (
+	DefaultStackLimit = 1 << 20
+	DefaultFrameLimit = 1 << 20
+)
+
+// SetLimits sets the maximum number of values on the stack and the frames
+// stack. Exceeding a limit is reported as a stack overflow. Stacks that are
+// already larger than their limits are cut down to them.
+func (m *VM) SetLimits(stackLimit int64, frameLimit int64) {
+	m.stackLimit = stackLimit
+	m.frameLimit = frameLimit
+	m.stack = clamp(m.stack, m.sp, stackLimit)
+	m.frames = clamp(m.frames, m.fsp, frameLimit)
+}
+
+// clamp shortens the stack s with n values on it to limit. Values on the
+// stack are never dropped.
+func clamp(s []Val, n int64, limit int64) []Val {
+	if limit < n {
+		limit = n
+	}
+	if int64(len(s)) > limit {
+		return s[:limit:limit]
+	}
+	return s
+}
+
+// ReserveGlobals makes room for n global definitions. The global definitions
+// grow on demand but a program that knows the number of its globals can
+// avoid the reallocations.
+func (m *VM) ReserveGlobals(n int) {
+	if n > len(m.defs) {
+		m.defs = append(m.defs, make([]Val, n-len(m.defs))...)
+	}
+}
+
+// grow doubles the size of the stack s without exceeding limit. It fails with
+// a stack overflow if the stack cannot grow any further.
+func (m *VM) grow(s []Val, limit int64) []Val {
+	n := int64(2 * len(s))
+	if n == 0 {
+		n = 16
+	}
+	if n > limit {
+		n = limit
+	}
+	if n <= int64(len(s)) {
+		panic(m.overflow(int64(len(s))))
+	}
+	t := make([]Val, n)
+	copy(t, s)
+	return t
+}
+
+func (m *VM) overflow(depth int64) *RuntimeError {
+	e := m.error(nil, "stack overflow at depth [%d]", depth)
+	e.Depth = depth
+	return e
+}
+
+func (m *VM) setGlobal(id int64, v Val) {
+	m.ReserveGlobals(int(id) + 1)
+	m.defs[id] = v
+}
+
+// getGlobal returns the value of the global definition. Reserved slots that
+// have never been set hold no value.
+func (m *VM) getGlobal(id int64) Val {
+	if id >= int64(len(m.defs)) || m.defs[id] == nil {
+		panic(m.error(nil, "undefined global [%d]", id))
+	}
+	return m.defs[id]
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 )
@@ -17,33 +18,142 @@ import (
 var end Val = nil
 
 type VM struct {
-	ip     int64
-	sp     int64
-	fp     int64
-	fsp    int64
-	defs   []Val
-	stack  []Val
-	frames []Val
-	code   Ins
+	ip         int64
+	lip        int64
+	sp         int64
+	fp         int64
+	fsp        int64
+	defs       []Val
+	stack      []Val
+	frames     []Val
+	stackLimit int64
+	frameLimit int64
+	handlers   []handler
+	code       Ins
+	dbg        *DebugInfo
 }
 
+// NewVM creates a new virtual machine. The sizes are the initial sizes of the
+// stacks and the global definitions. All of them grow on demand. The stacks
+// are bounded by DefaultStackLimit and DefaultFrameLimit unless SetLimits
+// configures different limits.
 func NewVM(stackSize int64, envStackSize int64, frameStackSize int64) *VM {
 	return &VM{
-		ip:     0,
-		sp:     0,
-		fp:     0,
-		fsp:    0,
-		defs:   make([]Val, envStackSize),
-		stack:  make([]Val, stackSize),
-		frames: make([]Val, frameStackSize),
+		ip:         0,
+		sp:         0,
+		fp:         0,
+		fsp:        0,
+		defs:       make([]Val, envStackSize),
+		stack:      make([]Val, stackSize),
+		frames:     make([]Val, frameStackSize),
+		stackLimit: DefaultStackLimit,
+		frameLimit: DefaultFrameLimit,
 	}
 }
 
-func (m *VM) Run(code Ins) {
+// SetDebugInfo provides function names and source positions for the code
+// that will be run next. They are used to report runtime errors.
+func (m *VM) SetDebugInfo(dbg *DebugInfo) {
+	m.dbg = dbg
+}
+
+// Run executes the code. It returns a *RuntimeError if the execution fails.
+// The machine is left in the state it was in when the failure occurred.
+func (m *VM) Run(code Ins) error {
 	m.code = code
-	ln := int64(len(code))
-	for m.ip = 0; m.ip < ln; {
-		switch m.readOp() {
+	return m.run(0)
+}
+
+// Append appends the code to the previously loaded program and executes only
+// the appended code. Functions created by previous runs remain callable
+// because their code addresses stay valid. Code must be assembled for the
+// base address CodeSize.
+func (m *VM) Append(code Ins) error {
+	start := m.CodeSize()
+	m.code = append(m.code, code...)
+	return m.run(start)
+}
+
+// Call calls the function fn with the arguments args and returns its result.
+// The function must have been loaded by Run or Append before. The stacks are
+// left as they were before the call. Natives may call back into Splis code
+// while the machine runs since the state of the current run is restored
+// afterwards.
+func (m *VM) Call(fn Val, args ...Val) (Val, error) {
+	if n, ok := fn.(*Native); ok {
+		v, err := n.call(args)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %v", n.Name, err)
+		}
+		return v, nil
+	}
+	f, ok := fn.(*Ref)
+	if !ok {
+		return nil, fmt.Errorf("cannot call [%v]", fn)
+	}
+	ip, lip, sp, fp, fsp, handlers := m.ip, m.lip, m.sp, m.fp, m.fsp, m.handlers
+	defer func() {
+		m.ip, m.lip, m.sp, m.fp, m.fsp, m.handlers = ip, lip, sp, fp, fsp, handlers
+	}()
+	// The handlers of the current run must not be overwritten by the call.
+	m.handlers = nil
+	m.push(end)
+	for i := len(args) - 1; i >= 0; i-- {
+		m.push(args[i])
+	}
+	// The function returns to the end of the code which ends the execution.
+	m.pushFrame(m.CodeSize())
+	m.pushFrame(m.fp)
+	for _, carg := range f.cargs {
+		m.push(carg)
+	}
+	m.fp = m.fsp
+	if err := m.run(f.addr); err != nil {
+		return nil, err
+	}
+	return m.pop(), nil
+}
+
+// CodeSize returns the size of the loaded program in bytes.
+func (m *VM) CodeSize() int64 {
+	return int64(len(m.code))
+}
+
+// Reset clears the stack and the frames stack. Global definitions are kept.
+func (m *VM) Reset() {
+	m.sp = 0
+	m.fp = 0
+	m.fsp = 0
+	m.handlers = m.handlers[:0]
+}
+
+// run executes the code starting at address start. Errors raised inside a try
+// block continue at the handler of that block.
+func (m *VM) run(start int64) error {
+	m.ip = start
+	m.handlers = m.handlers[:0]
+	for {
+		err := m.exec()
+		if err == nil || !m.catch(err) {
+			return err
+		}
+	}
+}
+
+// exec executes the code from the current instruction until the end of the
+// program or the first error.
+func (m *VM) exec() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = m.recoverError(r)
+		}
+	}()
+
+	ln := int64(len(m.code))
+	for m.ip < ln {
+		// Remember the address of the current instruction for error reporting.
+		m.lip = m.ip
+		switch op := m.readOp(); op {
 		case OpConst:
 			c := m.readInt64()
 			// fmt.Printf("Const %d\n", c)
@@ -54,6 +164,8 @@ func (m *VM) Run(code Ins) {
 			m.push(c)
 		case OpFalse:
 			m.push(false)
+		case OpNil:
+			m.push(Nil{})
 		case OpTrue:
 			m.push(true)
 		case OpEmptyVector:
@@ -61,6 +173,12 @@ func (m *VM) Run(code Ins) {
 		case OpStr:
 			l := m.readUint64()
 			m.push(m.readString(int64(l)))
+		case OpKeyword:
+			l := m.readUint64()
+			m.push(Intern(m.readString(int64(l))))
+		case OpSymbol:
+			l := m.readUint64()
+			m.push(NewSymbol(m.readString(int64(l))))
 		case OpPop:
 			m.pop()
 			// fmt.Printf("Pop\n")
@@ -88,10 +206,16 @@ func (m *VM) Run(code Ins) {
 		case OpMod:
 			r := m.popInt64()
 			l := m.popInt64()
+			if r == 0 {
+				panic(m.error([]Val{l, r}, "division by zero"))
+			}
 			m.push(l % r)
 		case OpRand:
 			rand.Seed(time.Now().UnixNano())
 			n := m.popInt64()
+			if n <= 0 {
+				panic(m.error([]Val{n}, "upper bound [%d] must be positive", n))
+			}
 			m.push(rand.Int63n(n))
 		case OpNot:
 			v := m.popBool()
@@ -102,43 +226,114 @@ func (m *VM) Run(code Ins) {
 				l = append(l, v)
 			}
 			m.push(l)
+		case OpMakeList:
+			l := make([]Val, 0)
+			for v := m.pop(); v != end; v = m.pop() {
+				l = append(l, v)
+			}
+			m.push(NewList(l))
 		case OpCons:
 			v := m.pop()
-			l := m.popVector()
+			l, isList := m.popSeq()
 			// TODO: This will not create a copy of the vector.
-			m.push(prepend(v, l))
+			m.push(seq(prepend(v, l), isList))
 		case OpAppend:
 			v := m.pop()
-			l := m.popVector()
+			l, isList := m.popSeq()
+			if isList {
+				// Lists are shared and must not be modified in place.
+				l = append([]Val{}, l...)
+			}
 			// TODO: This will not create a copy of the vector.
-			m.push(append(l, v))
+			m.push(seq(append(l, v), isList))
 		case OpConcat:
 			l := make([]Val, 0)
 			for v := m.pop(); v != end; v = m.pop() {
-				if vl, ok := v.([]Val); ok {
+				switch vl := v.(type) {
+				case []Val:
 					l = append(l, vl...)
+				case *List:
+					l = append(l, vl.Items...)
 				}
 			}
 			m.push(l)
 		case OpNth:
-			l := m.popVector()
+			l, _ := m.popSeq()
 			n := m.popInt64()
-			// TODO: panic if null?
+			if n < 0 || n >= int64(len(l)) {
+				panic(m.error([]Val{n, l}, "index [%d] out of bounds [%d]", n, len(l)))
+			}
 			m.push(l[n])
 		case OpDrop:
-			l := m.popVector()
+			l, isList := m.popSeq()
 			n := m.popInt64()
-			if len(l) == 0 {
+			switch {
+			case n < 0:
+				panic(m.error([]Val{n, l}, "cannot drop [%d] elements", n))
+			case n >= int64(len(l)):
 				// TODO: push fresh empty vector?
-				m.push(l)
-			} else {
-				m.push(l[n:])
+				m.push(seq(l[len(l):], isList))
+			default:
+				m.push(seq(l[n:], isList))
 			}
 		case OpLength:
-			l := m.popVector()
-			m.push(int64(len(l)))
+			switch x := m.pop().(type) {
+			case []Val:
+				m.push(int64(len(x)))
+			case *List:
+				m.push(int64(len(x.Items)))
+			case *Map:
+				m.push(int64(x.Len()))
+			default:
+				panic(m.typeError("vector, list or map", x))
+			}
+		case OpMap:
+			n := NewMap()
+			for k := m.pop(); k != end; k = m.pop() {
+				n.set(k, m.pop())
+			}
+			m.push(n)
+		case OpGet:
+			n := m.popMap()
+			k := m.pop()
+			d := m.pop()
+			if d != end && m.pop() != end {
+				panic(m.error([]Val{n, k}, "[get] expects at most a single default value"))
+			}
+			if v, ok := n.Get(k); ok {
+				m.push(v)
+			} else if d != end {
+				m.push(d)
+			} else {
+				panic(m.error([]Val{n, k}, "key [%v] not found", k))
+			}
+		case OpAssoc:
+			n := m.popMap().copy()
+			for k := m.pop(); k != end; k = m.pop() {
+				v := m.pop()
+				if v == end {
+					panic(m.error([]Val{n, k}, "[assoc] missing value for key [%v]", k))
+				}
+				n.set(k, v)
+			}
+			m.push(n)
+		case OpDissoc:
+			n := m.popMap()
+			for k := m.pop(); k != end; k = m.pop() {
+				n = n.Dissoc(k)
+			}
+			m.push(n)
+		case OpContains:
+			k := m.pop()
+			n := m.popMap()
+			_, ok := n.Get(k)
+			m.push(ok)
+		case OpKeys:
+			m.push(m.popMap().Keys())
+		case OpVals:
+			m.push(m.popMap().Vals())
 		case OpDissolve:
-			l := m.popVector()
+			l, _ := m.popSeq()
 			for i := len(l) - 1; i >= 0; i-- {
 				m.push(l[i])
 			}
@@ -202,6 +397,22 @@ func (m *VM) Run(code Ins) {
 			r := m.pop()
 			l := m.pop()
 			m.push(m.le(l, r))
+		case OpAtom:
+			m.push(&Atom{Val: m.pop()})
+		case OpDeref:
+			m.push(m.popAtom().Val)
+		case OpReset:
+			v := m.pop()
+			m.popAtom().Val = v
+			m.push(v)
+		case OpIs:
+			t := m.readUint64()
+			if t >= uint64(len(typeNames)) {
+				panic(m.error(nil, "unknown type code [%d]", t))
+			}
+			m.push(TypeName(m.pop()) == typeNames[t])
+		case OpTypeOf:
+			m.push(Intern(TypeName(m.pop())))
 		case OpJump:
 			m.ip = m.readInt64()
 			// fmt.Printf("Jump\n")
@@ -235,11 +446,21 @@ func (m *VM) Run(code Ins) {
 			// fmt.Printf("GetArg @[%d + %d] = %v\n", m.fp, d, m.frames[a])
 			// m.printFrames()
 			m.push(m.frames[a])
+		case OpSetArg:
+			// Replaces the argument FRAMES[FP + n] with the top of the stack.
+			m.frames[m.fp+m.readInt64()] = m.pop()
+		case OpMakeCell:
+			m.push(&Cell{Val: m.pop()})
+		case OpGetCell:
+			m.push(m.popCell().Val)
+		case OpSetCell:
+			v := m.pop()
+			m.popCell().Val = v
 		case OpSetGlobal:
-			m.defs[m.readInt64()] = m.pop()
+			m.setGlobal(m.readInt64(), m.pop())
 			// fmt.Printf("SetGlobal\n")
 		case OpGetGlobal:
-			m.push(m.defs[m.readInt64()])
+			m.push(m.getGlobal(m.readInt64()))
 			// fmt.Printf("GetGlobal\n")
 		case OpRef:
 			n := m.readInt64()
@@ -252,27 +473,52 @@ func (m *VM) Run(code Ins) {
 			}
 			// fmt.Printf("Ref %v @%v\n", r.cargs, r.addr)
 			m.push(r)
+		case OpPatchRef:
+			// Replaces the closure argument that is bound to the nth parameter of
+			// the function. Recursive local functions capture themselves this way.
+			k := m.readInt64()
+			v := m.pop()
+			f := m.pop()
+			r, ok := f.(*Ref)
+			if !ok {
+				panic(m.typeError("fn", f))
+			}
+			r.cargs[int64(len(r.cargs))-1-k] = v
 		case OpCall:
-			m.pushFrame(m.ip) // Push IP.
-			m.pushFrame(m.fp) // Push pointer to previous frame.
-			r := m.popRef()
-			// Push closue arguments.
-			for _, carg := range r.cargs {
-				m.push(carg)
+			switch f := m.pop().(type) {
+			case *Native:
+				m.push(m.callNative(f))
+			case *Ref:
+				m.pushFrame(m.ip) // Push IP.
+				m.pushFrame(m.fp) // Push pointer to previous frame.
+				// Push closue arguments.
+				for _, carg := range f.cargs {
+					m.push(carg)
+				}
+				m.fp = m.fsp  // Set pointer to new frame.
+				m.ip = f.addr // Call function.
+			default:
+				panic(m.typeError("fn", f))
 			}
-			// fmt.Printf("Call @%d\n", r.addr)
-			// m.printStack()
-			// m.printFrames()
-			m.fp = m.fsp  // Set pointer to new frame.
-			m.ip = r.addr // Call function.
-		case OpRecCall:
-			r := m.popRef()
-			// Push closue arguments.
-			for _, carg := range r.cargs {
-				m.push(carg)
+		case OpTailCall:
+			switch f := m.pop().(type) {
+			case *Native:
+				// A native function has no frame of its own. Return its result from
+				// the calling function.
+				m.push(m.callNative(f))
+				m.fsp = m.fp             // Drop arguments.
+				m.fp = m.popFrameInt64() // Restore pointer to previous frame.
+				m.ip = m.popFrameInt64() // Restore IP.
+			case *Ref:
+				// Push closue arguments.
+				for _, carg := range f.cargs {
+					m.push(carg)
+				}
+				m.fsp = m.fp  // Drop arguments.
+				m.ip = f.addr // Call function.
+			default:
+				panic(m.typeError("fn", f))
 			}
-			m.fsp = m.fp  // Drop arguments.
-			m.ip = r.addr // Call function.
 		case OpReturn:
 			m.fsp = m.fp             // Drop arguments.
 			m.fp = m.popFrameInt64() // Restore pointer to previous frame.
@@ -281,8 +527,24 @@ func (m *VM) Run(code Ins) {
 		case OpEnd:
 			m.push(end)
 			// fmt.Printf("End\n")
+		case OpArgc:
+			// Pushes the number of arguments below the n closure arguments.
+			m.push(m.countArgs(m.readInt64()))
+		case OpArityError:
+			// Fails with the function name on top of the stack and the arguments
+			// below the n closure arguments.
+			n := m.readInt64()
+			name := m.pop()
+			panic(m.error(nil, "[%v] does not accept [%d] arguments", name, m.countArgs(n)))
 		case OpHalt:
-			return
+			return nil
+		case OpTry:
+			ip := m.readInt64()
+			m.pushHandler(ip)
+		case OpEndTry:
+			m.popHandler()
+		case OpThrow:
+			panic(m.throw(m.pop()))
 		case OpWrite:
 			f := m.popFileDesc()
 			for v := m.pop(); v != end; v = m.pop() {
@@ -302,15 +564,33 @@ func (m *VM) Run(code Ins) {
 			}
 			fmt.Print("\n")
 		default:
-			panic("Unsupported operation.")
+			panic(m.error(nil, "unsupported operation [%d]", op))
 		}
 		// m.printStack()
 		// m.printFrames()
 		// fmt.Printf("---\n")
 	}
+	return nil
+}
+
+// recoverError converts a recovered panic into a runtime error. Errors raised
+// by the machine itself are passed through unchanged while Go runtime errors
+// are wrapped in a runtime error for the current instruction.
+func (m *VM) recoverError(r interface{}) error {
+	switch e := r.(type) {
+	case *RuntimeError:
+		return e
+	case runtime.Error:
+		return m.error(nil, "%v", e)
+	default:
+		panic(r)
+	}
 }
 
 func (m *VM) push(v Val) {
+	if m.sp == int64(len(m.stack)) {
+		m.stack = m.grow(m.stack, m.stackLimit)
+	}
 	m.stack[m.sp] = v
 	m.sp++
 }
@@ -319,40 +599,97 @@ func (m *VM) push(v Val) {
 // 	return m.stack[m.sp-1]
 // }
 
+// countArgs returns the number of arguments on the stack up to the end
+// marker. The top n values are skipped.
+func (m *VM) countArgs(n int64) int64 {
+	c := int64(0)
+	for i := m.sp - 1 - n; i >= 0 && m.stack[i] != end; i-- {
+		c++
+	}
+	return c
+}
+
 func (m *VM) pop() Val {
 	m.sp--
 	return m.stack[m.sp]
 }
 
 func (m *VM) popBool() bool {
-	return m.pop().(bool)
+	v := m.pop()
+	if x, ok := v.(bool); ok {
+		return x
+	}
+	panic(m.typeError("bool", v))
 }
 
 func (m *VM) popInt64() int64 {
-	return m.pop().(int64)
+	v := m.pop()
+	if x, ok := v.(int64); ok {
+		return x
+	}
+	panic(m.typeError("int", v))
 }
 
 func (m *VM) popUInt64() uint64 {
-	return m.pop().(uint64)
+	v := m.pop()
+	if x, ok := v.(uint64); ok {
+		return x
+	}
+	panic(m.typeError("uint", v))
 }
 
 func (m *VM) popStr() string {
-	return m.pop().(string)
+	v := m.pop()
+	if x, ok := v.(string); ok {
+		return x
+	}
+	panic(m.typeError("string", v))
 }
 
 func (m *VM) popVector() []Val {
-	return m.pop().([]Val)
+	v := m.pop()
+	if x, ok := v.([]Val); ok {
+		return x
+	}
+	panic(m.typeError("vector", v))
 }
 
-func (m *VM) popFileDesc() *os.File {
-	return m.pop().(*os.File)
+func (m *VM) popMap() *Map {
+	v := m.pop()
+	if x, ok := v.(*Map); ok {
+		return x
+	}
+	panic(m.typeError("map", v))
+}
+
+func (m *VM) popAtom() *Atom {
+	v := m.pop()
+	if x, ok := v.(*Atom); ok {
+		return x
+	}
+	panic(m.typeError("atom", v))
+}
+
+func (m *VM) popCell() *Cell {
+	v := m.pop()
+	if x, ok := v.(*Cell); ok {
+		return x
+	}
+	panic(m.typeError("cell", v))
 }
 
-func (m *VM) popRef() *Ref {
-	return m.pop().(*Ref)
+func (m *VM) popFileDesc() *os.File {
+	v := m.pop()
+	if x, ok := v.(*os.File); ok {
+		return x
+	}
+	panic(m.typeError("file", v))
 }
 
 func (m *VM) pushFrame(v Val) {
+	if m.fsp == int64(len(m.frames)) {
+		m.frames = m.grow(m.frames, m.frameLimit)
+	}
 	m.frames[m.fsp] = v
 	m.fsp++
 }
@@ -405,7 +742,7 @@ func (m *VM) add(l Val, r Val) Val {
 		case float64:
 			return float64(ll) + rr
 		default:
-			panic(fmt.Sprintf("Cannot add %v", rr))
+			panic(m.error([]Val{l, r}, "cannot add [%s]", TypeName(rr)))
 		}
 	case float64:
 		switch rr := r.(type) {
@@ -414,10 +751,10 @@ func (m *VM) add(l Val, r Val) Val {
 		case float64:
 			return ll + rr
 		default:
-			panic(fmt.Sprintf("Cannot add %v", rr))
+			panic(m.error([]Val{l, r}, "cannot add [%s]", TypeName(rr)))
 		}
 	default:
-		panic(fmt.Sprintf("Cannot add %v", ll))
+		panic(m.error([]Val{l, r}, "cannot add [%s]", TypeName(ll)))
 	}
 }
 
@@ -430,7 +767,7 @@ func (m *VM) sub(l Val, r Val) Val {
 		case float64:
 			return float64(ll) - rr
 		default:
-			panic(fmt.Sprintf("Cannot subtract %v", rr))
+			panic(m.error([]Val{l, r}, "cannot subtract [%s]", TypeName(rr)))
 		}
 	case float64:
 		switch rr := r.(type) {
@@ -439,10 +776,10 @@ func (m *VM) sub(l Val, r Val) Val {
 		case float64:
 			return ll - rr
 		default:
-			panic(fmt.Sprintf("Cannot subtract %v", rr))
+			panic(m.error([]Val{l, r}, "cannot subtract [%s]", TypeName(rr)))
 		}
 	default:
-		panic(fmt.Sprintf("Cannot subtract %v", ll))
+		panic(m.error([]Val{l, r}, "cannot subtract [%s]", TypeName(ll)))
 	}
 }
 
@@ -455,7 +792,7 @@ func (m *VM) mul(l Val, r Val) Val {
 		case float64:
 			return float64(ll) * rr
 		default:
-			panic(fmt.Sprintf("Cannot multiply %v", rr))
+			panic(m.error([]Val{l, r}, "cannot multiply [%s]", TypeName(rr)))
 		}
 	case float64:
 		switch rr := r.(type) {
@@ -464,10 +801,10 @@ func (m *VM) mul(l Val, r Val) Val {
 		case float64:
 			return ll * rr
 		default:
-			panic(fmt.Sprintf("Cannot multiply %v", rr))
+			panic(m.error([]Val{l, r}, "cannot multiply [%s]", TypeName(rr)))
 		}
 	default:
-		panic(fmt.Sprintf("Cannot multiply %v", ll))
+		panic(m.error([]Val{l, r}, "cannot multiply [%s]", TypeName(ll)))
 	}
 }
 
@@ -476,11 +813,14 @@ func (m *VM) div(l Val, r Val) Val {
 	case int64:
 		switch rr := r.(type) {
 		case int64:
+			if rr == 0 {
+				panic(m.error([]Val{l, r}, "division by zero"))
+			}
 			return ll / rr //float64(ll) / float64(rr)
 		case float64:
 			return float64(ll) / rr
 		default:
-			panic(fmt.Sprintf("Cannot divide %v", rr))
+			panic(m.error([]Val{l, r}, "cannot divide [%s]", TypeName(rr)))
 		}
 	case float64:
 		switch rr := r.(type) {
@@ -489,10 +829,10 @@ func (m *VM) div(l Val, r Val) Val {
 		case float64:
 			return ll / rr
 		default:
-			panic(fmt.Sprintf("Cannot divide %v", rr))
+			panic(m.error([]Val{l, r}, "cannot divide [%s]", TypeName(rr)))
 		}
 	default:
-		panic(fmt.Sprintf("Cannot divide %v", ll))
+		panic(m.error([]Val{l, r}, "cannot divide [%s]", TypeName(ll)))
 	}
 }
 
@@ -527,6 +867,27 @@ func (m *VM) eq(l Val, r Val) bool {
 		case []Val:
 			return m.eqSeq(ll, rr)
 		}
+	case *List:
+		switch rr := r.(type) {
+		case *List:
+			return m.eqSeq(ll.Items, rr.Items)
+		}
+	case *Map:
+		switch rr := r.(type) {
+		case *Map:
+			return m.eqMap(ll, rr)
+		}
+	case *Keyword, *Atom:
+		// Keywords are interned. Atoms are equal only if they are identical.
+		return l == r
+	case *Symbol:
+		switch rr := r.(type) {
+		case *Symbol:
+			return ll.Name == rr.Name
+		}
+	case Nil:
+		_, ok := r.(Nil)
+		return ok
 	}
 	return false
 }
@@ -543,6 +904,19 @@ func (m *VM) eqSeq(l []Val, r []Val) bool {
 	return true
 }
 
+func (m *VM) eqMap(l *Map, r *Map) bool {
+	if l.Len() != r.Len() {
+		return false
+	}
+	for i, k := range l.keys {
+		v, ok := r.Get(k)
+		if !ok || !m.eq(l.vals[i], v) {
+			return false
+		}
+	}
+	return true
+}
+
 func (m *VM) lt(l Val, r Val) bool {
 	switch ll := l.(type) {
 	case int64:
@@ -552,7 +926,7 @@ func (m *VM) lt(l Val, r Val) bool {
 		case float64:
 			return float64(ll) < rr
 		default:
-			panic(fmt.Sprintf("Cannot < %v", rr))
+			panic(m.error([]Val{l, r}, "cannot < [%s]", TypeName(rr)))
 		}
 	case float64:
 		switch rr := r.(type) {
@@ -561,10 +935,10 @@ func (m *VM) lt(l Val, r Val) bool {
 		case float64:
 			return ll < rr
 		default:
-			panic(fmt.Sprintf("Cannot < %v", rr))
+			panic(m.error([]Val{l, r}, "cannot < [%s]", TypeName(rr)))
 		}
 	default:
-		panic(fmt.Sprintf("Cannot < %v", ll))
+		panic(m.error([]Val{l, r}, "cannot < [%s]", TypeName(ll)))
 	}
 }
 
@@ -577,7 +951,7 @@ func (m *VM) le(l Val, r Val) bool {
 		case float64:
 			return float64(ll) <= rr
 		default:
-			panic(fmt.Sprintf("Cannot <= %v", rr))
+			panic(m.error([]Val{l, r}, "cannot <= [%s]", TypeName(rr)))
 		}
 	case float64:
 		switch rr := r.(type) {
@@ -586,10 +960,10 @@ func (m *VM) le(l Val, r Val) bool {
 		case float64:
 			return ll <= rr
 		default:
-			panic(fmt.Sprintf("Cannot <= %v", rr))
+			panic(m.error([]Val{l, r}, "cannot <= [%s]", TypeName(rr)))
 		}
 	default:
-		panic(fmt.Sprintf("Cannot <= %v", ll))
+		panic(m.error([]Val{l, r}, "cannot <= [%s]", TypeName(ll)))
 	}
 }
 
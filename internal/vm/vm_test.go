@@ -1,8 +1,11 @@
 package vm_test
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mhoertnagl/noodles/internal/vm"
@@ -617,6 +620,122 @@ func TestRunSet2(t *testing.T) {
 	)
 }
 
+func TestRunSetArg(t *testing.T) {
+	testToS(t, int64(42),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 2),
+		vm.Instr(vm.OpPushArgs, 2),
+		vm.Instr(vm.OpConst, 42),
+		vm.Instr(vm.OpSetArg, 1),
+		vm.Instr(vm.OpGetArg, 1),
+	)
+}
+
+func TestRunPatchRef(t *testing.T) {
+	testToS(t, int64(7),
+		vm.Instr(vm.OpJump, 29),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpReturn),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpRef, 1, 9),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpConst, 7),
+		vm.Instr(vm.OpPatchRef, 0),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpCall),
+	)
+}
+
+func TestRunPatchRefError(t *testing.T) {
+	testRunError(t, vm.OpPatchRef,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 7),
+		vm.Instr(vm.OpPatchRef, 0),
+	)
+}
+
+func TestRunCell(t *testing.T) {
+	testToS(t, int64(2),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpMakeCell),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpConst, 2),
+		vm.Instr(vm.OpSetCell),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpGetCell),
+	)
+}
+
+func TestRunCellError(t *testing.T) {
+	testRunError(t, vm.OpGetCell,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpGetCell),
+	)
+}
+
+func TestRunArgc(t *testing.T) {
+	testToS(t, int64(2),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 2),
+		vm.Instr(vm.OpConst, 3),
+		vm.Instr(vm.OpArgc, 1),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpList),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpGetArg, 0),
+	)
+}
+
+func TestRunArgcNone(t *testing.T) {
+	testToS(t, int64(0),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpArgc, 0),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpGetArg, 0),
+	)
+}
+
+func TestRunArityError(t *testing.T) {
+	err := testRunError(t, vm.OpArityError,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Str("f"),
+		vm.Instr(vm.OpArityError, 0),
+	)
+	if e := "[f] does not accept [1] arguments"; err.Msg != e {
+		t.Errorf("Expecting [%s] but got [%s]", e, err.Msg)
+	}
+}
+
+func TestRunAtom(t *testing.T) {
+	testToS(t, int64(2),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpAtom),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpConst, 2),
+		vm.Instr(vm.OpReset),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpDeref),
+	)
+}
+
+func TestRunAtomError(t *testing.T) {
+	testRunError(t, vm.OpReset,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 2),
+		vm.Instr(vm.OpReset),
+	)
+}
+
 // --- LET ---
 
 func TestRunLet1(t *testing.T) {
@@ -1260,7 +1379,7 @@ func TestRunTailFac(t *testing.T) {
 		vm.Instr(vm.OpConst, 1),
 		vm.Instr(vm.OpSub),
 		vm.Instr(vm.OpGetGlobal, 0),
-		vm.Instr(vm.OpRecCall),
+		vm.Instr(vm.OpTailCall),
 		vm.Instr(vm.OpReturn),
 		vm.Instr(vm.OpRef, 0, 9),
 		vm.Instr(vm.OpSetGlobal, 0),
@@ -1433,6 +1552,617 @@ func TestRunWrite2(t *testing.T) {
 	// EXPECTED: [1 2 3]
 }
 
+func TestRunErrorAddType(t *testing.T) {
+	e := testRunError(t, vm.OpAdd,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpTrue),
+		vm.Instr(vm.OpAdd),
+	)
+	testVal(t, int64(11), e.IP)
+	testVal(t, []vm.Val{int64(0), true}, e.Args)
+}
+
+func TestRunErrorNthOutOfBounds(t *testing.T) {
+	e := testRunError(t, vm.OpNth,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpEmptyVector),
+		vm.Instr(vm.OpNth),
+	)
+	testVal(t, []vm.Val{int64(1), []vm.Val{}}, e.Args)
+}
+
+func TestRunErrorPopInt64(t *testing.T) {
+	e := testRunError(t, vm.OpMod,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpFalse),
+		vm.Instr(vm.OpMod),
+	)
+	testVal(t, []vm.Val{false}, e.Args)
+}
+
+func TestRunErrorDivisionByZero(t *testing.T) {
+	testRunError(t, vm.OpDiv,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 0),
+		vm.Instr(vm.OpDiv),
+	)
+}
+
+func TestRunErrorCallNonFunction(t *testing.T) {
+	testRunError(t, vm.OpCall,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpCall),
+	)
+}
+
+func TestStackGrows(t *testing.T) {
+	m := vm.NewVM(1, 0, 1)
+	code := []vm.Ins{}
+	for i := 0; i < 100; i++ {
+		code = append(code, vm.Instr(vm.OpConst, uint64(i)))
+	}
+	code = append(code, vm.Instr(vm.OpPushArgs, 50))
+	if err := m.Run(vm.Concat(code)); err != nil {
+		t.Fatalf("Unexpected runtime error [%v].", err)
+	}
+	testVal(t, int64(50), m.StackSize())
+	testVal(t, int64(49), m.InspectStack(0))
+	testVal(t, int64(99), m.InspectFrames(0))
+}
+
+func TestStackOverflow(t *testing.T) {
+	m := vm.NewVM(4, 0, 4)
+	m.SetLimits(8, 8)
+	code := []vm.Ins{}
+	for i := 0; i < 9; i++ {
+		code = append(code, vm.Instr(vm.OpConst, uint64(i)))
+	}
+	err := m.Run(vm.Concat(code))
+	rerr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error but got [%v].", err)
+	}
+	testVal(t, "stack overflow at depth [8]", rerr.Msg)
+	testVal(t, int64(8), rerr.Depth)
+}
+
+func TestStackLimitBelowInitialSize(t *testing.T) {
+	m := vm.NewVM(1024, 0, 512)
+	m.SetLimits(8, 4)
+	code := []vm.Ins{}
+	for i := 0; i < 9; i++ {
+		code = append(code, vm.Instr(vm.OpConst, uint64(i)))
+	}
+	err := m.Run(vm.Concat(code))
+	rerr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error but got [%v].", err)
+	}
+	testVal(t, int64(8), rerr.Depth)
+}
+
+func TestFrameStackOverflow(t *testing.T) {
+	m := vm.NewVM(4, 0, 4)
+	m.SetLimits(1024, 16)
+	// A function that calls itself without ever returning.
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpRef, 0, 26),
+		vm.Instr(vm.OpSetGlobal, 0),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpGetGlobal, 0),
+		vm.Instr(vm.OpCall),
+	))
+	rerr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error but got [%v].", err)
+	}
+	testVal(t, int64(16), rerr.Depth)
+}
+
+func TestGlobalsGrow(t *testing.T) {
+	m := vm.NewVM(4, 0, 4)
+	m.AddGlobal(600, int64(42))
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpGetGlobal, 600),
+		vm.Instr(vm.OpSetGlobal, 700),
+		vm.Instr(vm.OpGetGlobal, 700),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected runtime error [%v].", err)
+	}
+	testVal(t, int64(42), m.InspectStack(0))
+}
+
+func TestRunErrorUndefinedGlobal(t *testing.T) {
+	testRunError(t, vm.OpGetGlobal,
+		vm.Instr(vm.OpGetGlobal, 1000),
+	)
+}
+
+func TestRunErrorUnsetGlobal(t *testing.T) {
+	m := vm.NewVM(1024, 0, 512)
+	m.ReserveGlobals(4)
+	err := m.Run(vm.Instr(vm.OpGetGlobal, 3))
+	if rerr, ok := err.(*vm.RuntimeError); !ok || rerr.Msg != "undefined global [3]" {
+		t.Errorf("Expecting an undefined global but got [%v]", err)
+	}
+}
+
+func TestMapLiteral(t *testing.T) {
+	m := testRun(t,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 2),
+		vm.Str("b"),
+		vm.Instr(vm.OpConst, 1),
+		vm.Str("a"),
+		vm.Instr(vm.OpMap),
+	)
+	n := m.InspectStack(0).(*vm.Map)
+	testVal(t, 2, n.Len())
+	testVal(t, "{a 1 b 2}", fmt.Sprint(n))
+	testVal(t, []vm.Val{"a", "b"}, n.Keys())
+	testVal(t, []vm.Val{int64(1), int64(2)}, n.Vals())
+}
+
+func TestTryThrow(t *testing.T) {
+	testToS(t, int64(42),
+		vm.Instr(vm.OpTry, 21),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 42),
+		vm.Instr(vm.OpThrow),
+		vm.Instr(vm.OpEndTry),
+		vm.Instr(vm.OpHalt),
+	)
+}
+
+func TestThrowUncaught(t *testing.T) {
+	e := testRunError(t, vm.OpThrow,
+		vm.Instr(vm.OpConst, 42),
+		vm.Instr(vm.OpThrow),
+	)
+	testVal(t, int64(42), e.Value)
+	testVal(t, "uncaught exception", e.Msg)
+}
+
+func TestCatchRuntimeError(t *testing.T) {
+	m := testRun(t,
+		vm.Instr(vm.OpTry, 29),
+		vm.Instr(vm.OpConst, 0),
+		vm.Instr(vm.OpConst, 0),
+		vm.Instr(vm.OpDiv),
+		vm.Instr(vm.OpEndTry),
+	)
+	testMapGet(t, m.InspectStack(0).(*vm.Map), vm.Intern("op"), "Div")
+	testVal(t, int64(1), m.StackSize())
+}
+
+func TestCall(t *testing.T) {
+	m := vm.NewVM(1024, 512, 512)
+	// (fn [a b] (- a b))
+	code := vm.Concat([]vm.Ins{
+		vm.Instr(vm.OpRef, 0, 26),
+		vm.Instr(vm.OpJump, 56),
+		vm.Instr(vm.OpPushArgs, 2),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpGetArg, 1),
+		vm.Instr(vm.OpSub),
+		vm.Instr(vm.OpReturn),
+	})
+	if err := m.Run(code); err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	fn := m.InspectStack(0)
+	v, err := m.Call(fn, int64(5), int64(3))
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	testVal(t, int64(2), v)
+	testVal(t, int64(1), m.StackSize())
+	if _, err := m.Call(fn, "a", int64(3)); err == nil {
+		t.Errorf("Expecting a runtime error")
+	}
+	testVal(t, int64(1), m.StackSize())
+}
+
+func TestCallReentrant(t *testing.T) {
+	m := vm.NewVM(1024, 512, 512)
+	// A native that calls its argument twice while the machine is running.
+	m.AddGlobal(0, vm.NewNative("twice", 1, func(args []vm.Val) (vm.Val, error) {
+		s := int64(0)
+		for i := 0; i < 2; i++ {
+			v, err := m.Call(args[0], int64(20))
+			if err != nil {
+				return nil, err
+			}
+			s += v.(int64)
+		}
+		return s, nil
+	}))
+	// (+ 100 (try (twice (fn [a] (- a 1))) (catch e e)))
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpJump, 39),
+		vm.Instr(vm.OpPushArgs, 1),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpGetArg, 0),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpSub),
+		vm.Instr(vm.OpReturn),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 100),
+		vm.Instr(vm.OpTry, 87),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpRef, 0, 9),
+		vm.Instr(vm.OpGetGlobal, 0),
+		vm.Instr(vm.OpCall),
+		vm.Instr(vm.OpEndTry),
+		vm.Instr(vm.OpAdd),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected runtime error [%v].", err)
+	}
+	testVal(t, int64(138), m.InspectStack(0))
+	testVal(t, int64(1), m.StackSize())
+}
+
+func TestCallNativeArity(t *testing.T) {
+	n := vm.NewNative("none", 1, func(args []vm.Val) (vm.Val, error) {
+		return nil, nil
+	})
+	m := testNativeVM(n)
+	if _, err := m.Call(n); err == nil || err.Error() != "[none] expects [1] arguments but got [0]" {
+		t.Errorf("Unexpected error [%v].", err)
+	}
+	if _, err := m.Call(n, int64(1)); err == nil || err.Error() != "[none] returned no value" {
+		t.Errorf("Unexpected error [%v].", err)
+	}
+}
+
+func TestMakeList(t *testing.T) {
+	m := testRun(t,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.StrInstr(vm.OpSymbol, "a"),
+		vm.Instr(vm.OpMakeList),
+	)
+	l := m.InspectStack(0).(*vm.List)
+	testVal(t, "(a 1)", fmt.Sprint(l))
+	testVal(t, vm.NewSymbol("a"), l.Items[0])
+}
+
+func TestListOps(t *testing.T) {
+	list := func(c ...vm.Ins) []vm.Ins {
+		return append([]vm.Ins{
+			vm.Instr(vm.OpEnd),
+			vm.Instr(vm.OpConst, 2),
+			vm.Instr(vm.OpConst, 1),
+			vm.Instr(vm.OpMakeList),
+		}, c...)
+	}
+	testToS(t, vm.NewList([]vm.Val{int64(0), int64(1), int64(2)}),
+		list(vm.Instr(vm.OpConst, 0), vm.Instr(vm.OpCons))...)
+	testToS(t, vm.NewList([]vm.Val{int64(1), int64(2), int64(3)}),
+		list(vm.Instr(vm.OpConst, 3), vm.Instr(vm.OpAppend))...)
+	testToS(t, int64(2), list(vm.Instr(vm.OpLength))...)
+	testToS(t, vm.NewList([]vm.Val{int64(2)}),
+		append([]vm.Ins{vm.Instr(vm.OpConst, 1)}, list(vm.Instr(vm.OpDrop))...)...)
+	testToS(t, int64(2),
+		append([]vm.Ins{vm.Instr(vm.OpConst, 1)}, list(vm.Instr(vm.OpNth))...)...)
+}
+
+func TestNil(t *testing.T) {
+	m := testRun(t,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpList),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpEQ),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpFalse),
+		vm.Instr(vm.OpEQ),
+	)
+	testVal(t, false, m.InspectStack(0))
+	testVal(t, true, m.InspectStack(1))
+	testVal(t, "[nil 1 nil]", fmt.Sprint(m.InspectStack(2)))
+}
+
+func TestIs(t *testing.T) {
+	m := testRun(t,
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpIs, vm.TypeInt),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpIs, vm.TypeFloat),
+		vm.Instr(vm.OpNil),
+		vm.Instr(vm.OpIs, vm.TypeNil),
+		vm.Str("a"),
+		vm.Instr(vm.OpIs, vm.TypeStr),
+	)
+	testVal(t, true, m.InspectStack(0))
+	testVal(t, true, m.InspectStack(1))
+	testVal(t, false, m.InspectStack(2))
+	testVal(t, true, m.InspectStack(3))
+}
+
+func TestTypeOf(t *testing.T) {
+	testToS(t, vm.Intern("float"),
+		vm.Instr(vm.OpConstF, math.Float64bits(1.5)),
+		vm.Instr(vm.OpTypeOf),
+	)
+}
+
+func TestKeyword(t *testing.T) {
+	m := testRun(t,
+		vm.StrInstr(vm.OpKeyword, "a"),
+		vm.StrInstr(vm.OpKeyword, "a"),
+		vm.Instr(vm.OpEQ),
+		vm.StrInstr(vm.OpKeyword, "a"),
+		vm.StrInstr(vm.OpKeyword, "b"),
+		vm.Instr(vm.OpEQ),
+		vm.StrInstr(vm.OpKeyword, "key"),
+	)
+	testVal(t, ":key", fmt.Sprint(m.InspectStack(0)))
+	testVal(t, false, m.InspectStack(1))
+	testVal(t, true, m.InspectStack(2))
+	if vm.Intern("a") != vm.Intern("a") {
+		t.Errorf("Keywords with the same name must be identical.")
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	n := vm.NewMap().
+		Assoc(int64(1), "int").
+		Assoc([]vm.Val{int64(1), "x"}, "vector").
+		Assoc(vm.NewMap().Assoc("a", int64(1)), "map")
+	testMapGet(t, n, 1.0, "int")
+	testMapGet(t, n, []vm.Val{1.0, "x"}, "vector")
+	testMapGet(t, n, vm.NewMap().Assoc("a", 1.0), "map")
+	if _, ok := n.Get("1"); ok {
+		t.Errorf("String [1] must not match integer key [1].")
+	}
+	if _, ok := n.Get([]vm.Val{"1 \"x\""}); ok {
+		t.Errorf("String must not match vector key.")
+	}
+	testVal(t, 2, n.Dissoc(1.0).Len())
+	testVal(t, 3, n.Len())
+}
+
+func TestMapGetDefault(t *testing.T) {
+	testToS(t, int64(7),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 7),
+		vm.Str("x"),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpMap),
+		vm.Instr(vm.OpGet),
+	)
+}
+
+func TestMapAssocDissoc(t *testing.T) {
+	m := testRun(t,
+		vm.Instr(vm.OpEnd),
+		vm.Str("a"),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 2),
+		vm.Str("b"),
+		vm.Instr(vm.OpConst, 1),
+		vm.Str("a"),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpMap),
+		vm.Instr(vm.OpAssoc),
+		vm.Instr(vm.OpDissoc),
+	)
+	testVal(t, "{b 2}", fmt.Sprint(m.InspectStack(0)))
+}
+
+func TestMapEquality(t *testing.T) {
+	testToS(t, true,
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Str("a"),
+		vm.Instr(vm.OpConst, 2),
+		vm.Str("b"),
+		vm.Instr(vm.OpMap),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 2),
+		vm.Str("b"),
+		vm.Instr(vm.OpConst, 1),
+		vm.Str("a"),
+		vm.Instr(vm.OpMap),
+		vm.Instr(vm.OpEQ),
+	)
+}
+
+func TestRunErrorMapKeyNotFound(t *testing.T) {
+	e := testRunError(t, vm.OpGet,
+		vm.Instr(vm.OpEnd),
+		vm.Str("x"),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpMap),
+		vm.Instr(vm.OpGet),
+	)
+	testVal(t, "key [x] not found", e.Msg)
+}
+
+func TestCallNative(t *testing.T) {
+	m := testNativeVM(vm.NewNative("sub", 2, func(args []vm.Val) (vm.Val, error) {
+		return args[0].(int64) - args[1].(int64), nil
+	}))
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 43),
+		vm.Instr(vm.OpGetGlobal, 0),
+		vm.Instr(vm.OpCall),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected runtime error [%v].", err)
+	}
+	testVal(t, int64(42), m.InspectStack(0))
+	testVal(t, int64(1), m.StackSize())
+}
+
+func TestCallNativeVariadic(t *testing.T) {
+	m := testNativeVM(vm.NewNative("count", vm.Variadic, func(args []vm.Val) (vm.Val, error) {
+		return int64(len(args)), nil
+	}))
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpGetGlobal, 0),
+		vm.Instr(vm.OpCall),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected runtime error [%v].", err)
+	}
+	testVal(t, int64(0), m.InspectStack(0))
+}
+
+func TestCallNativeArityMismatch(t *testing.T) {
+	m := testNativeVM(vm.NewNative("id", 1, func(args []vm.Val) (vm.Val, error) {
+		return args[0], nil
+	}))
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpConst, 2),
+		vm.Instr(vm.OpGetGlobal, 0),
+		vm.Instr(vm.OpCall),
+	))
+	if err == nil || !strings.Contains(err.Error(), "[id] expects [1] arguments but got [2]") {
+		t.Errorf("Unexpected error [%v].", err)
+	}
+}
+
+func TestCallNativeError(t *testing.T) {
+	m := testNativeVM(vm.NewNative("fail", 0, func(args []vm.Val) (vm.Val, error) {
+		return nil, errors.New("boom")
+	}))
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpGetGlobal, 0),
+		vm.Instr(vm.OpCall),
+	))
+	rerr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error but got [%v].", err)
+	}
+	testVal(t, vm.OpCall, rerr.Op)
+	testVal(t, "[fail] boom", rerr.Msg)
+}
+
+func TestRunErrorUnsupportedOperation(t *testing.T) {
+	e := testRunError(t, 255, []byte{255})
+	testVal(t, int64(0), e.IP)
+}
+
+func TestRunErrorInspectable(t *testing.T) {
+	m := vm.NewVM(1024, 512, 512)
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpConst, 42),
+		vm.Instr(vm.OpConst, 1),
+		vm.Instr(vm.OpEmptyVector),
+		vm.Instr(vm.OpNth),
+	))
+	if err == nil {
+		t.Fatalf("Expected a runtime error but got none.")
+	}
+	testVal(t, int64(42), m.InspectStack(0))
+}
+
+func TestRunErrorBacktrace(t *testing.T) {
+	m := vm.NewVM(1024, 512, 512)
+	m.SetDebugInfo(&vm.DebugInfo{
+		Fns: []vm.FnInfo{
+			{Name: "bad", Start: 9, End: 22},
+		},
+		Lines: []vm.LineInfo{
+			{Addr: 0, Pos: vm.SrcPos{File: "t", Line: 1, Col: 1}},
+			{Addr: 9, Pos: vm.SrcPos{File: "t", Line: 2, Col: 3}},
+			{Addr: 40, Pos: vm.SrcPos{File: "t", Line: 5, Col: 1}},
+		},
+	})
+	err := m.Run(vm.ConcatVar(
+		vm.Instr(vm.OpJump, 22),
+		vm.Instr(vm.OpPop),
+		vm.Instr(vm.OpConst, 5),
+		vm.Instr(vm.OpEmptyVector),
+		vm.Instr(vm.OpNth),
+		vm.Instr(vm.OpReturn),
+		vm.Instr(vm.OpEnd),
+		vm.Instr(vm.OpRef, 0, 9),
+		vm.Instr(vm.OpCall),
+	))
+	rerr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error but got [%v].", err)
+	}
+	e := "  at bad [20] (t:2:3)\n  at <top> [40] (t:5:1)\n"
+	if a := rerr.Backtrace(); a != e {
+		t.Errorf("Expected backtrace\n%s\nbut got\n%s", e, a)
+	}
+}
+
+func TestDebugInfoEncodeDecode(t *testing.T) {
+	e := &vm.DebugInfo{
+		Fns: []vm.FnInfo{
+			{Name: "f", Start: 9, End: 22, Pos: vm.SrcPos{File: "a", Line: 1, Col: 2}},
+		},
+		Lines: []vm.LineInfo{
+			{Addr: 3, Pos: vm.SrcPos{File: "b", Line: 3, Col: 4}},
+		},
+	}
+	a, err := vm.DecodeDebugInfo(e.Encode())
+	if err != nil {
+		t.Fatalf("Unexpected error [%v].", err)
+	}
+	testVal(t, e, a)
+	if _, err := vm.DecodeDebugInfo([]byte{1, 2}); err == nil {
+		t.Errorf("Expected an error for malformed debug information.")
+	}
+}
+
+func TestObjectEncodeDecode(t *testing.T) {
+	e := &vm.Object{
+		Code:    vm.Instr(vm.OpConst, 42),
+		Globals: []string{"*STD-IN*", "f"},
+		Debug: &vm.DebugInfo{
+			Fns:   []vm.FnInfo{{Name: "f", Start: 0, End: 9}},
+			Lines: []vm.LineInfo{},
+		},
+	}
+	a, err := vm.DecodeObject(e.Encode())
+	if err != nil {
+		t.Fatalf("Unexpected error [%v].", err)
+	}
+	testVal(t, e, a)
+}
+
+func TestDecodeObjectErrors(t *testing.T) {
+	b := (&vm.Object{Code: vm.Instr(vm.OpHalt)}).Encode()
+	testDecodeObjectError(t, []byte("#!/bin/sh"), "not a noodles object file")
+	v := append([]byte{}, b...)
+	v[5]++
+	testDecodeObjectError(t, v, "unsupported object file format [2], expecting [1]")
+	o := append([]byte{}, b...)
+	o[9]++
+	if _, err := vm.DecodeObject(o); err == nil || !strings.Contains(err.Error(), "recompile the program") {
+		t.Errorf("Unexpected error [%v].", err)
+	}
+	testDecodeObjectError(t, b[:len(b)-1], "malformed object file")
+	testDecodeObjectError(t, b[:10], "object file has no code section")
+}
+
+func testDecodeObjectError(t *testing.T, b []byte, e string) {
+	t.Helper()
+	if _, err := vm.DecodeObject(b); err == nil || err.Error() != e {
+		t.Errorf("Expected error [%s] but got [%v].", e, err)
+	}
+}
+
 // testToS executes a sequence of instructions in the vm and tests the top of
 // the stack element against an expected value. Will raise an error if the
 // types or the values are unequal. The stack is fixed to a maximum size of
@@ -1451,10 +2181,48 @@ func testToS(t *testing.T, expected vm.Val, c ...vm.Ins) {
 func testRun(t *testing.T, c ...vm.Ins) *vm.VM {
 	t.Helper()
 	m := vm.NewVM(1024, 512, 512)
-	m.Run(vm.Concat(c))
+	if err := m.Run(vm.Concat(c)); err != nil {
+		t.Fatalf("Unexpected runtime error [%v].", err)
+	}
+	return m
+}
+
+func testMapGet(t *testing.T, m *vm.Map, k vm.Val, e vm.Val) {
+	t.Helper()
+	v, ok := m.Get(k)
+	if !ok {
+		t.Fatalf("Key [%v] not found.", k)
+	}
+	testVal(t, e, v)
+}
+
+// testNativeVM creates a new VM instance with the native function n bound to
+// the global ID 0.
+func testNativeVM(n *vm.Native) *vm.VM {
+	m := vm.NewVM(1024, 512, 512)
+	m.AddGlobal(0, n)
 	return m
 }
 
+// testRunError executes a new VM instance with the code provided and expects
+// the execution to fail with a runtime error for the operation op.
+func testRunError(t *testing.T, op vm.Op, c ...vm.Ins) *vm.RuntimeError {
+	t.Helper()
+	m := vm.NewVM(1024, 512, 512)
+	err := m.Run(vm.Concat(c))
+	if err == nil {
+		t.Fatalf("Expected a runtime error but got none.")
+	}
+	rerr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error but got [%T].", err)
+	}
+	if rerr.Op != op {
+		t.Errorf("Expected error in operation [%d] but got [%d].", op, rerr.Op)
+	}
+	return rerr
+}
+
 // testVal compares the expected and the actual values for equal types and
 // values. It will raise an error otherwise.
 func testVal(t *testing.T, expected vm.Val, actual vm.Val) {
@@ -0,0 +1,167 @@
+// Package noodles embeds the Splis compiler and virtual machine into Go
+// programs.
+//
+//	rt, err := noodles.NewRuntime(&noodles.Options{Prelude: true})
+//	v, err := rt.Eval(`(sum [1 2 3])`)
+package noodles
+
+import (
+	"os"
+	"path"
+
+	"github.com/mhoertnagl/noodles/internal/session"
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// Value is a value of the virtual machine. Use ToGo and FromGo to convert
+// values to and from Go values.
+type Value = vm.Val
+
+// Variadic is the arity of native functions that accept any number of
+// arguments.
+const Variadic = vm.Variadic
+
+// Func is the signature of Go functions that can be called from Splis code.
+// A non-nil error aborts the program with a runtime error.
+type Func = vm.NativeFn
+
+// Native is a Go function that is callable by its name.
+type Native = vm.Native
+
+// NewNative creates a native function with the given name. The arity is the
+// exact number of arguments the function expects or Variadic.
+func NewNative(name string, arity int, fn Func) *Native {
+	return vm.NewNative(name, arity, fn)
+}
+
+// Options configure the compilation and the execution of programs.
+type Options struct {
+	// File is the name of the source file used in diagnostics.
+	File string
+	// Dirs are searched for modules that are used by a program. Defaults to
+	// '$(SPLIS_HOME)/lib' if the environment variable is set.
+	Dirs []string
+	// Prelude loads the module core/prelude into a new runtime.
+	Prelude bool
+	// Args are passed to programs in *ARGS*.
+	Args []string
+	// Natives are bound to their names in the global definitions.
+	Natives []*Native
+	// StackLimit is the maximum number of values on the stack. Zero selects
+	// the default limit.
+	StackLimit int64
+	// FrameLimit is the maximum number of values on the frames stack that
+	// holds arguments and local bindings. Zero selects the default limit.
+	FrameLimit int64
+}
+
+// limits returns the stack limits with defaults for unset limits.
+func (o *Options) limits() (int64, int64) {
+	stack, frame := o.StackLimit, o.FrameLimit
+	if stack == 0 {
+		stack = vm.DefaultStackLimit
+	}
+	if frame == 0 {
+		frame = vm.DefaultFrameLimit
+	}
+	return stack, frame
+}
+
+func (o *Options) dirs() []string {
+	if o.Dirs != nil {
+		return o.Dirs
+	}
+	if home, ok := os.LookupEnv("SPLIS_HOME"); ok {
+		return []string{path.Join(home, "lib")}
+	}
+	return []string{}
+}
+
+func withDefaults(opts *Options) *Options {
+	if opts == nil {
+		return &Options{}
+	}
+	return opts
+}
+
+// Program is a compiled program that can be run any number of times.
+type Program struct {
+	prg *session.Program
+}
+
+// Compile compiles the source code of a whole program.
+func Compile(src string, opts *Options) (*Program, error) {
+	opts = withDefaults(opts)
+	prg, err := session.Compile(opts.dirs(), opts.File, src, opts.Natives...)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{prg: prg}, nil
+}
+
+// Runtime evaluates source code incrementally. Global definitions and macros
+// persist between evaluations.
+type Runtime struct {
+	opts    *Options
+	s       *session.Session
+	natives []*Native
+}
+
+// NewRuntime creates a new runtime and loads the core prelude if requested.
+func NewRuntime(opts *Options) (*Runtime, error) {
+	opts = withDefaults(opts)
+	r := &Runtime{
+		opts: opts,
+		s:    session.New(opts.dirs()),
+	}
+	r.s.SetArgs(opts.Args)
+	r.s.SetLimits(opts.limits())
+	for _, n := range opts.Natives {
+		r.register(n)
+	}
+	if opts.Prelude {
+		if _, err := r.Eval(`(use "core/prelude")`); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register binds a Go function to name. The function is visible to all
+// subsequent evaluations and runs of programs that have been compiled with a
+// native function of the same name.
+func (r *Runtime) Register(name string, arity int, fn Func) {
+	r.register(NewNative(name, arity, fn))
+}
+
+func (r *Runtime) register(n *Native) {
+	r.s.Register(n)
+	r.natives = append(r.natives, n)
+}
+
+// Eval compiles and executes all forms of src and returns the value of the
+// last form. It returns nil if the last form does not produce a value.
+func (r *Runtime) Eval(src string) (Value, error) {
+	v, _, err := r.s.Eval(r.opts.File, src)
+	return v, err
+}
+
+// Run executes a compiled program on a fresh machine. The program does not
+// see the definitions made by Eval. It returns the value of the last form of
+// the program if there is one.
+func (r *Runtime) Run(p *Program) (Value, error) {
+	m := vm.NewVM(1024, 0, 512)
+	m.ReserveGlobals(len(p.prg.Globals))
+	m.AddDefaultGlobals()
+	m.SetArgs(r.opts.Args)
+	m.SetLimits(r.opts.limits())
+	m.SetDebugInfo(p.prg.Debug)
+	p.prg.Link(m, r.natives...)
+	if err := m.Run(p.prg.Code); err != nil {
+		return nil, err
+	}
+	if m.StackSize() > 0 {
+		return m.InspectStack(0), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,212 @@
+package noodles_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mhoertnagl/noodles/noodles"
+)
+
+func TestEval(t *testing.T) {
+	rt, err := noodles.NewRuntime(&noodles.Options{Prelude: true})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	testEval(t, rt, `(defn sq [x] (* x x))`, nil)
+	testEval(t, rt, `(map sq [1 2 3])`, []interface{}{int64(1), int64(4), int64(9)})
+}
+
+func TestEvalArgs(t *testing.T) {
+	rt, err := noodles.NewRuntime(&noodles.Options{Args: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	testEval(t, rt, `*ARGS*`, []interface{}{"a", "b"})
+}
+
+func TestEvalError(t *testing.T) {
+	rt, err := noodles.NewRuntime(&noodles.Options{File: "t"})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if _, err := rt.Eval(`(+ 1 x)`); err == nil || err.Error() != "t:1:6: unknown symbol [x]" {
+		t.Errorf("Unexpected error [%v]", err)
+	}
+}
+
+func TestEvalLimits(t *testing.T) {
+	src := `(do (def f (fn [n] (if (= n 0) 0 (+ 1 (f (- n 1)))))) (f 5000))`
+	rt, err := noodles.NewRuntime(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	testEval(t, rt, src, int64(5000))
+	rt, err = noodles.NewRuntime(&noodles.Options{FrameLimit: 2048})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if _, err := rt.Eval(src); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("Expecting a stack overflow but got [%v]", err)
+	}
+}
+
+func TestCompileAndRun(t *testing.T) {
+	p, err := noodles.Compile(`(do (use "core/prelude") (sum [1 2 3]))`, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	rt, err := noodles.NewRuntime(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	for i := 0; i < 2; i++ {
+		v, err := rt.Run(p)
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		if v != int64(6) {
+			t.Errorf("Expecting [6] but got [%v]", v)
+		}
+	}
+}
+
+func TestNative(t *testing.T) {
+	rt, err := noodles.NewRuntime(&noodles.Options{Prelude: true})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	rt.Register("upper", 1, func(args []noodles.Value) (noodles.Value, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("expects a string")
+		}
+		return strings.ToUpper(s), nil
+	})
+	testEval(t, rt, `(map upper ["a" "b"])`, []interface{}{"A", "B"})
+	if _, err := rt.Eval(`(upper 1)`); err == nil || !strings.Contains(err.Error(), "[upper] expects a string") {
+		t.Errorf("Unexpected error [%v]", err)
+	}
+}
+
+func TestNativeCompileAndRun(t *testing.T) {
+	n := noodles.NewNative("add", noodles.Variadic, func(args []noodles.Value) (noodles.Value, error) {
+		s := int64(0)
+		for _, a := range args {
+			s += a.(int64)
+		}
+		return s, nil
+	})
+	opts := &noodles.Options{Natives: []*noodles.Native{n}}
+	p, err := noodles.Compile(`(add 1 2 3)`, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	rt, err := noodles.NewRuntime(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if v, err := rt.Run(p); err != nil || v != int64(6) {
+		t.Errorf("Expecting [6] but got [%v] [%v]", v, err)
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	testFromGo(t, 42, int64(42))
+	testFromGo(t, uint8(42), int64(42))
+	testFromGo(t, float32(0.5), 0.5)
+	testFromGo(t, "x", "x")
+	testFromGo(t, []int{1, 2}, []noodles.Value{int64(1), int64(2)})
+	testFromGo(t, []interface{}{true, []string{"a"}}, []noodles.Value{true, []noodles.Value{"a"}})
+	if _, err := noodles.FromGo(make(chan int)); err == nil {
+		t.Errorf("Expecting an error for unsupported types")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rt, err := noodles.NewRuntime(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	v, err := rt.Eval(`[1 2.5 "x" [true nil]]`)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	e := []interface{}{int64(1), 2.5, "x", []interface{}{true, nil}}
+	g := noodles.ToGo(v)
+	if !reflect.DeepEqual(e, g) {
+		t.Errorf("Expecting [%v] but got [%v]", e, g)
+	}
+	w, err := noodles.FromGo(g)
+	if err != nil || !reflect.DeepEqual(v, w) {
+		t.Errorf("Expecting [%v] but got [%v]", v, w)
+	}
+}
+
+func TestRoundTripMap(t *testing.T) {
+	rt, err := noodles.NewRuntime(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	v, err := rt.Eval(`{"a" {:b 2.5} [1 2] :c}`)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	e := map[interface{}]interface{}{
+		"a":                                map[interface{}]interface{}{noodles.Keyword("b"): 2.5},
+		[2]interface{}{int64(1), int64(2)}: noodles.Keyword("c"),
+	}
+	g := noodles.ToGo(v)
+	if !reflect.DeepEqual(e, g) {
+		t.Errorf("Expecting [%v] but got [%v]", e, g)
+	}
+	w, err := noodles.FromGo(g)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if a := noodles.ToGo(w); !reflect.DeepEqual(e, a) {
+		t.Errorf("Expecting [%v] but got [%v]", e, a)
+	}
+}
+
+func TestToGoList(t *testing.T) {
+	rt, err := noodles.NewRuntime(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	testEval(t, rt, "`(a (b 1) :c)", []interface{}{
+		noodles.Symbol("a"),
+		[]interface{}{noodles.Symbol("b"), int64(1)},
+		noodles.Keyword("c"),
+	})
+	v, err := noodles.FromGo(noodles.Symbol("a"))
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if a := noodles.ToGo(v); a != noodles.Symbol("a") {
+		t.Errorf("Expecting [a] but got [%v]", a)
+	}
+}
+
+func testEval(t *testing.T, rt *noodles.Runtime, src string, e interface{}) {
+	t.Helper()
+	v, err := rt.Eval(src)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if a := noodles.ToGo(v); !reflect.DeepEqual(e, a) {
+		t.Errorf("Expecting [%v] but got [%v]", e, a)
+	}
+}
+
+func testFromGo(t *testing.T, x interface{}, e noodles.Value) {
+	t.Helper()
+	a, err := noodles.FromGo(x)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("Expecting [%v] but got [%v]", e, a)
+	}
+}
@@ -0,0 +1,145 @@
+package noodles
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mhoertnagl/noodles/internal/vm"
+)
+
+// Keyword is the Go counterpart of a keyword. It is the name of the keyword
+// without the leading colon.
+type Keyword string
+
+// Symbol is the Go counterpart of a symbol of quasi-quoted code.
+type Symbol string
+
+// ToGo converts a value of the virtual machine into a Go value. Integers
+// become int64, floating point numbers float64, keywords Keyword, symbols
+// Symbol, vectors and lists []interface{} and hash maps
+// map[interface{}]interface{}. nil becomes the Go nil. Values without a Go
+// counterpart such as functions are returned unchanged.
+func ToGo(v Value) interface{} {
+	switch x := v.(type) {
+	case []vm.Val:
+		return toGoSeq(x)
+	case *vm.List:
+		return toGoSeq(x.Items)
+	case *vm.Map:
+		m := make(map[interface{}]interface{}, x.Len())
+		for _, k := range x.Keys() {
+			e, _ := x.Get(k)
+			m[toGoKey(k)] = ToGo(e)
+		}
+		return m
+	case *vm.Keyword:
+		return Keyword(x.Name)
+	case *vm.Symbol:
+		return Symbol(x.Name)
+	case vm.Nil:
+		return nil
+	default:
+		return x
+	}
+}
+
+func toGoSeq(items []vm.Val) []interface{} {
+	l := make([]interface{}, len(items))
+	for i, e := range items {
+		l[i] = ToGo(e)
+	}
+	return l
+}
+
+// toGoKey converts a key of a hash map. Slices cannot be keys of Go maps.
+// Vectors and lists thus become arrays.
+func toGoKey(k Value) interface{} {
+	switch x := k.(type) {
+	case []vm.Val:
+		return toGoArray(x)
+	case *vm.List:
+		return toGoArray(x.Items)
+	case *vm.Map:
+		// Maps are keys by their identity.
+		return x
+	default:
+		return ToGo(x)
+	}
+}
+
+func toGoArray(items []vm.Val) interface{} {
+	a := reflect.New(reflect.ArrayOf(len(items), anyType)).Elem()
+	for i, e := range items {
+		if k := toGoKey(e); k != nil {
+			a.Index(i).Set(reflect.ValueOf(k))
+		}
+	}
+	return a.Interface()
+}
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// FromGo converts a Go value into a value of the virtual machine. Supported
+// are nil, booleans, strings, keywords, symbols, all integer and floating
+// point types as well as slices, arrays and maps thereof. Slices and arrays
+// become vectors.
+func FromGo(x interface{}) (Value, error) {
+	switch v := x.(type) {
+	case nil:
+		return vm.Nil{}, nil
+	case bool, string, int64, float64:
+		return v, nil
+	case Keyword:
+		return vm.Intern(string(v)), nil
+	case Symbol:
+		return vm.NewSymbol(string(v)), nil
+	case *vm.Map, *vm.Ref, *vm.Native, *vm.Atom:
+		// Values of the virtual machine are passed through.
+		return v, nil
+	case []interface{}:
+		return fromGoSeq(reflect.ValueOf(v))
+	}
+	rv := reflect.ValueOf(x)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Slice, reflect.Array:
+		return fromGoSeq(rv)
+	case reflect.Map:
+		return fromGoMap(rv)
+	}
+	return nil, fmt.Errorf("cannot convert [%T] to a value", x)
+}
+
+func fromGoSeq(rv reflect.Value) (Value, error) {
+	l := make([]vm.Val, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		e, err := FromGo(rv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		l[i] = e
+	}
+	return l, nil
+}
+
+func fromGoMap(rv reflect.Value) (Value, error) {
+	m := vm.NewMap()
+	it := rv.MapRange()
+	for it.Next() {
+		k, err := FromGo(it.Key().Interface())
+		if err != nil {
+			return nil, err
+		}
+		v, err := FromGo(it.Value().Interface())
+		if err != nil {
+			return nil, err
+		}
+		m = m.Assoc(k, v)
+	}
+	return m, nil
+}